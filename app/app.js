@@ -16,6 +16,16 @@ document.getElementById('video-draft-form').addEventListener('submit', async (ev
   await createVideoDraft();
 });
 
+document.getElementById('video-search-form').addEventListener('submit', async (event) => {
+  event.preventDefault();
+  const query = document.getElementById('video-search').value.trim();
+  if (query) {
+    await searchVideos(query);
+  } else {
+    await getVideos();
+  }
+});
+
 document.getElementById('login-form').addEventListener('submit', async (event) => {
   event.preventDefault();
   await login();
@@ -152,6 +162,62 @@ async function uploadThumbnail(videoID) {
   setUploadButtonState(false, uploadBtnSelector);
 }
 
+async function uploadCaptions(videoID) {
+  const captionsFile = document.getElementById('captions-file').files[0];
+  if (!captionsFile) return;
+
+  const formData = new FormData();
+  formData.append('captions', captionsFile);
+  formData.append('language', document.getElementById('captions-language').value);
+  formData.append('label', document.getElementById('captions-label').value);
+
+  uploadBtnSelector = 'upload-captions-btn';
+  setUploadButtonState(true, uploadBtnSelector);
+
+  try {
+    const res = await fetch(`/api/videos/${videoID}/captions`, {
+      method: 'POST',
+      headers: {
+        Authorization: `Bearer ${localStorage.getItem('token')}`,
+      },
+      body: formData,
+    });
+    if (!res.ok) {
+      const data = await res.json();
+      throw new Error(`Failed to upload captions. Error: ${data.error}`);
+    }
+
+    await res.json();
+    await getVideo(videoID);
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+
+  setUploadButtonState(false, uploadBtnSelector);
+}
+
+async function selectThumbnailCandidate(videoID, candidate) {
+  try {
+    const res = await fetch(`/api/videos/${videoID}/thumbnail`, {
+      method: 'PUT',
+      headers: {
+        Authorization: `Bearer ${localStorage.getItem('token')}`,
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({ candidate }),
+    });
+    if (!res.ok) {
+      const data = await res.json();
+      throw new Error(`Failed to select thumbnail. Error: ${data.error}`);
+    }
+
+    await res.json();
+    await getVideo(videoID);
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
 async function uploadVideoFile(videoID) {
   const videoFile = document.getElementById('video-file').files[0];
   if (!videoFile) return;
@@ -186,9 +252,10 @@ async function uploadVideoFile(videoID) {
 
 const videoStateHandler = createVideoStateHandler();
 
-async function getVideos() {
+async function getVideos(cursor) {
   try {
-    const res = await fetch('/api/videos', {
+    const url = cursor ? `/api/videos?cursor=${encodeURIComponent(cursor)}` : '/api/videos';
+    const res = await fetch(url, {
       method: 'GET',
       headers: {
         Authorization: `Bearer ${localStorage.getItem('token')}`,
@@ -199,15 +266,59 @@ async function getVideos() {
       throw new Error(`Failed to get videos. Error: ${data.error}`);
     }
 
-    const videos = await res.json();
+    const { videos, next_cursor } = await res.json();
     const videoList = document.getElementById('video-list');
-    videoList.innerHTML = '';
+    if (!cursor) {
+      videoList.innerHTML = '';
+    }
     for (const video of videos) {
       const listItem = document.createElement('li');
-      listItem.textContent = video.title;
+      listItem.textContent =
+        video.status && video.status !== 'ready' ? `${video.title} (${video.status})` : video.title;
       listItem.onclick = () => videoStateHandler(video.id);
       videoList.appendChild(listItem);
     }
+
+    const loadMore = document.getElementById('load-more-videos');
+    loadMore.style.display = next_cursor ? 'block' : 'none';
+    loadMore.onclick = () => getVideos(next_cursor);
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
+async function searchVideos(query) {
+  try {
+    const res = await fetch(`/api/videos/search?q=${encodeURIComponent(query)}`, {
+      method: 'GET',
+      headers: {
+        Authorization: `Bearer ${localStorage.getItem('token')}`,
+      },
+    });
+    const data = await res.json();
+    if (!res.ok) {
+      throw new Error(`Failed to search videos. Error: ${data.error}`);
+    }
+
+    const videoList = document.getElementById('video-list');
+    videoList.innerHTML = '';
+    for (const result of data) {
+      const listItem = document.createElement('li');
+      // the server escapes highlights and only adds <mark> tags
+      listItem.innerHTML = result.title_highlight;
+      if (result.description_snippet) {
+        const snippet = document.createElement('small');
+        snippet.innerHTML = result.description_snippet;
+        listItem.appendChild(document.createElement('br'));
+        listItem.appendChild(snippet);
+      }
+      listItem.onclick = () => videoStateHandler(result.video.id);
+      videoList.appendChild(listItem);
+    }
+    if (data.length === 0) {
+      videoList.innerHTML = '<li>No videos found</li>';
+    }
+    document.getElementById('load-more-videos').style.display = 'none';
   } catch (error) {
     alert(`Error: ${error.message}`);
   }
@@ -241,6 +352,7 @@ async function getVideo(videoID) {
       throw new Error('Failed to get video.');
     }
 
+    currentVideoETag = res.headers.get('ETag');
     const video = await res.json();
     viewVideo(video);
   } catch (error) {
@@ -249,12 +361,17 @@ async function getVideo(videoID) {
 }
 
 let currentVideo = null;
+let currentVideoETag = null;
 
 function viewVideo(video) {
   currentVideo = video;
   document.getElementById('video-display').style.display = 'block';
   document.getElementById('video-title-display').textContent = video.title;
   document.getElementById('video-description-display').textContent = video.description;
+  document.getElementById('edit-video-title').value = video.title;
+  document.getElementById('edit-video-description').value = video.description || '';
+  document.getElementById('video-status-display').textContent =
+    video.status === 'failed' ? `Failed: ${video.failure_reason}` : video.status;
 
   const thumbnailImg = document.getElementById('thumbnail-image');
   if (!video.thumbnail_url) {
@@ -262,8 +379,22 @@ function viewVideo(video) {
   } else {
     thumbnailImg.style.display = 'block';
     thumbnailImg.src = video.thumbnail_url;
+    thumbnailImg.srcset = Object.entries(video.thumbnails || {})
+      .map(([width, url]) => `${url} ${width}`)
+      .join(', ');
   }
 
+  const candidates = document.getElementById('thumbnail-candidates');
+  candidates.innerHTML = '';
+  (video.thumbnail_candidates || []).forEach((url, index) => {
+    const img = document.createElement('img');
+    img.src = url;
+    img.width = 160;
+    img.title = 'Use as thumbnail';
+    img.onclick = () => selectThumbnailCandidate(video.id, index);
+    candidates.appendChild(img);
+  });
+
   const videoPlayer = document.getElementById('video-player');
   if (videoPlayer) {
     if (!video.video_url) {
@@ -271,11 +402,57 @@ function viewVideo(video) {
     } else {
       videoPlayer.style.display = 'block';
       videoPlayer.src = video.video_url;
+      videoPlayer.querySelectorAll('track').forEach((track) => track.remove());
+      for (const caption of video.captions || []) {
+        const track = document.createElement('track');
+        track.kind = 'captions';
+        track.src = caption.url;
+        track.srclang = caption.language;
+        track.label = caption.label;
+        videoPlayer.appendChild(track);
+      }
       videoPlayer.load();
     }
   }
 }
 
+async function updateVideo() {
+  if (!currentVideo) {
+    return;
+  }
+  const title = document.getElementById('edit-video-title').value;
+  const description = document.getElementById('edit-video-description').value;
+
+  try {
+    const headers = {
+      'Content-Type': 'application/merge-patch+json',
+      Authorization: `Bearer ${localStorage.getItem('token')}`,
+    };
+    if (currentVideoETag) {
+      headers['If-Match'] = currentVideoETag;
+    }
+    const res = await fetch(`/api/videos/${currentVideo.id}`, {
+      method: 'PATCH',
+      headers,
+      body: JSON.stringify({ title, description }),
+    });
+    const data = await res.json();
+    if (res.status === 412) {
+      alert('This video was changed elsewhere, reloading it.');
+      await getVideo(currentVideo.id);
+      return;
+    }
+    if (!res.ok) {
+      throw new Error(`Failed to update video: ${data.error}`);
+    }
+    currentVideoETag = res.headers.get('ETag');
+    viewVideo(data);
+    await getVideos();
+  } catch (error) {
+    alert(`Error: ${error.message}`);
+  }
+}
+
 async function deleteVideo() {
   if (!currentVideo) {
     alert('No video selected for deletion.');
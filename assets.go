@@ -1,6 +1,8 @@
 package main
 
 import (
+	"io/fs"
+	"net/http"
 	"os"
 )
 
@@ -10,3 +12,26 @@ func (cfg apiConfig) ensureAssetsDir() error {
 	}
 	return nil
 }
+
+// fileOnlyFS serves files but not directory listings, so nobody can browse
+// what is stored
+type fileOnlyFS struct {
+	http.FileSystem
+}
+
+func (fsys fileOnlyFS) Open(name string) (http.File, error) {
+	f, err := fsys.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if stat.IsDir() {
+		f.Close()
+		return nil, fs.ErrNotExist
+	}
+	return f, nil
+}
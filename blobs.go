@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// storeBlob stores content with the given SHA-256 under key, unless the
+// same content is already stored, and returns the key that holds it.
+// Nothing references the blob until it is attached to a video.
+func (cfg *apiConfig) storeBlob(ctx context.Context, key, hash string, body io.Reader, size int64, contentType string) (string, error) {
+	existing, err := cfg.db.GetBlobByHash(hash)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		return existing.Key, nil
+	}
+
+	err = cfg.storage.Put(ctx, key, body, contentType)
+	if err != nil {
+		return "", err
+	}
+	err = cfg.db.CreateBlob(database.Blob{
+		Key:         key,
+		Hash:        &hash,
+		Size:        size,
+		ContentType: contentType,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// a concurrent upload of the same content may have won
+	winner, err := cfg.db.GetBlobByHash(hash)
+	if err != nil {
+		return "", err
+	}
+	if winner == nil {
+		return "", errors.New("blob vanished right after it was stored")
+	}
+	if winner.Key != key {
+		cfg.storage.Delete(ctx, key)
+	}
+	return winner.Key, nil
+}
+
+// discardBlobs deletes blobs stored for a write that didn't happen, unless
+// something attached them in the meantime
+func (cfg *apiConfig) discardBlobs(keys []string) {
+	discarded := []string{}
+	for _, key := range keys {
+		deleted, err := cfg.db.DeleteUnreferencedBlob(key)
+		if err != nil {
+			log.Printf("couldn't discard blob %s: %v", key, err)
+			continue
+		}
+		if deleted {
+			discarded = append(discarded, key)
+		}
+	}
+	cfg.enqueueDeletion(discarded, nil)
+}
+
+// fileSHA256 returns the hex SHA-256 of the file at path
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -1,10 +1,21 @@
 package main
 
-import "net/http"
+import (
+	"net/http"
+	"regexp"
+)
+
+// contentAddressed matches path segments that are a SHA-256 or random
+// 256 bit hex name. Files under such names are never rewritten in place.
+var contentAddressed = regexp.MustCompile(`(^|/)[0-9a-f]{64}(\.[a-z0-9]+)?(/|$)`)
 
 func cacheMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Cache-Control", "max-age=3600")
+		if contentAddressed.MatchString(r.URL.Path) {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "max-age=3600")
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// jobDeleteObjects removes objects from storage in the background so a
+// failing backend doesn't fail the request that made them unreachable
+const jobDeleteObjects = "delete_objects"
+
+type deleteObjectsJob struct {
+	Keys []string `json:"keys"`
+	// Prefixes are deleted along with everything under them
+	Prefixes []string `json:"prefixes"`
+}
+
+// enqueueDeletion schedules keys and prefixes for deletion
+func (cfg *apiConfig) enqueueDeletion(keys, prefixes []string) {
+	if len(keys) == 0 && len(prefixes) == 0 {
+		return
+	}
+	err := cfg.jobs.Enqueue(jobDeleteObjects, deleteObjectsJob{Keys: keys, Prefixes: prefixes})
+	if err != nil {
+		log.Printf("couldn't queue deletion of %v %v: %v", keys, prefixes, err)
+	}
+}
+
+// deleteBlobs schedules released blobs for deletion
+func (cfg *apiConfig) deleteBlobs(blobs []database.Blob) {
+	keys := make([]string, 0, len(blobs))
+	for _, blob := range blobs {
+		keys = append(keys, blob.Key)
+	}
+	cfg.enqueueDeletion(keys, nil)
+}
+
+// videoPrefixes are where the files generated for a single video live
+func videoPrefixes(videoID uuid.UUID) []string {
+	return []string{
+		"hls/" + videoID.String() + "/",
+		"previews/" + videoID.String() + "/",
+		directUploadPrefix(videoID),
+	}
+}
+
+// deleteVideoMedia schedules everything a deleted video left in storage:
+// the blobs it was the last user of, its generated files, and a video file
+// from before blobs were tracked
+func (cfg *apiConfig) deleteVideoMedia(video database.Video, released []database.Blob) {
+	keys := make([]string, 0, len(released)+1)
+	for _, blob := range released {
+		keys = append(keys, blob.Key)
+	}
+	if video.VideoURL != nil {
+		// skipped by the job if another video still uses it as a blob
+		if key, ok := cfg.storageKey(*video.VideoURL); ok {
+			keys = append(keys, key)
+		}
+	}
+	cfg.enqueueDeletion(keys, videoPrefixes(video.ID))
+}
+
+func (cfg *apiConfig) handleDeleteObjectsJob(ctx context.Context, payload json.RawMessage) error {
+	var job deleteObjectsJob
+	err := json.Unmarshal(payload, &job)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+
+	for _, key := range job.Keys {
+		// an identical upload may have stored the same key again since
+		blob, err := cfg.db.GetBlob(key)
+		if err != nil {
+			return err
+		}
+		if blob != nil {
+			continue
+		}
+		err = cfg.storage.Delete(ctx, key)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+	}
+
+	for _, prefix := range job.Prefixes {
+		objects, err := cfg.storage.List(ctx, prefix)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			err = cfg.storage.Delete(ctx, obj.Key)
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return err
+			}
+		}
+	}
+	return nil
+}
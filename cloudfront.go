@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cloudfront"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// loadCloudFrontSigner reads the key pair for CF_SIGNING. An empty mode
+// means CloudFront URLs are handed out unsigned.
+func loadCloudFrontSigner(mode, keyPairID, keyFile string) (*cloudfront.Signer, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case "url", "cookie":
+	default:
+		return nil, fmt.Errorf("CF_SIGNING must be \"url\" or \"cookie\", got %q", mode)
+	}
+	if keyPairID == "" || keyFile == "" {
+		return nil, errors.New("CF_SIGNING needs CF_KEY_PAIR_ID and CF_PRIVATE_KEY_FILE")
+	}
+	pemData, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := cloudfront.ParsePrivateKey(pemData)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't parse CloudFront private key: %w", err)
+	}
+	return cloudfront.NewSigner(keyPairID, key), nil
+}
+
+// cloudFrontPolicy covers resource until cfg.presignTTL from now, from the
+// configured IP range if there is one
+func (cfg *apiConfig) cloudFrontPolicy(resource string) cloudfront.Policy {
+	return cloudfront.Policy{
+		Resource: resource,
+		Expires:  time.Now().Add(cfg.presignTTL),
+		SourceIP: cfg.cfSourceIP,
+	}
+}
+
+// setCloudFrontCookies hands out signed cookies for the directory of the
+// video's HLS playlist when cookie signing is on. Everything else gets a
+// signed URL, but segments are fetched relative to the playlist and can
+// only be reached with cookies. They are scoped to that directory, by
+// policy and by cookie path, so each video's cookies only open its own
+// segments. The browser only sends them to CloudFront if CF_COOKIE_DOMAIN
+// is a parent domain of both the API and the distribution.
+func (cfg *apiConfig) setCloudFrontCookies(w http.ResponseWriter, video database.Video) error {
+	if cfg.cfSigning != "cookie" || video.HLSManifestURL == nil {
+		return nil
+	}
+	key, ok := cfg.storageKey(*video.HLSManifestURL)
+	if !ok {
+		return nil
+	}
+	prefix := path.Dir(key) + "/"
+	policy := cfg.cloudFrontPolicy("https://" + cfg.cfDomain + "/" + prefix + "*")
+	cookies, err := cfg.cfSigner.Cookies(policy)
+	if err != nil {
+		return err
+	}
+	for _, cookie := range cookies {
+		cookie.Domain = cfg.cfCookieDomain
+		cookie.Path = "/" + prefix
+		http.SetCookie(w, cookie)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+)
+
+// runCommand runs a one-off admin command instead of starting the server,
+// e.g. `go run . migrate-thumbnails`
+func (cfg *apiConfig) runCommand(args []string) error {
+	switch args[0] {
+	case "migrate-thumbnails":
+		return cfg.migrateThumbnails(context.Background())
+	case "gc":
+		return cfg.runGC(context.Background(), args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// runMigrate manages the database schema: `migrate up` applies pending
+// migrations, `migrate down [n]` reverts the last n (default 1) and
+// `migrate status` lists them
+func runMigrate(pathToDB string, args []string) error {
+	db, err := database.Open(pathToDB)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	command := "status"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		n, err := db.MigrateUp()
+		if err != nil {
+			return err
+		}
+		log.Printf("applied %d migrations", n)
+		return nil
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations %q", args[1])
+			}
+		}
+		return db.MigrateDown(steps)
+	case "status":
+		statuses, err := db.MigrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if s.Modified {
+				state += " (modified since)"
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q", command)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// gcGrace keeps gc away from objects an upload or job may still be about to
+// reference
+const gcGrace = 24 * time.Hour
+
+// gcPrefixes are the storage prefixes tubely stores objects under
+var gcPrefixes = []string{
+	media.OrientationLandscape + "/",
+	media.OrientationPortrait + "/",
+	media.OrientationOther + "/",
+	"thumbnails/",
+	"hls/",
+	"previews/",
+	"captions/",
+	"uploads/",
+}
+
+// runGC reconciles storage against the videos table and deletes objects no
+// video references, then expired resumable uploads, e.g.
+// `go run . gc -dry-run`
+func (cfg *apiConfig) runGC(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only list what would be deleted")
+	grace := flags.Duration("grace", gcGrace, "leave objects younger than this alone")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	videos, err := cfg.db.GetAllVideos()
+	if err != nil {
+		return fmt.Errorf("couldn't list videos: %w", err)
+	}
+	blobs, err := cfg.db.GetBlobs()
+	if err != nil {
+		return fmt.Errorf("couldn't list blobs: %w", err)
+	}
+	// the bucket may hold things that aren't ours, so only look where
+	// tubely puts objects
+	objects := []storage.ObjectInfo{}
+	for _, prefix := range gcPrefixes {
+		listed, err := cfg.storage.List(ctx, prefix)
+		if err != nil {
+			return fmt.Errorf("couldn't list storage: %w", err)
+		}
+		objects = append(objects, listed...)
+	}
+
+	refs := cfg.storageReferences(videos)
+	cutoff := time.Now().Add(-*grace)
+
+	// blob rows nothing was ever attached to, from uploads that failed
+	// between storing and saving the video
+	for _, blob := range blobs {
+		if blob.RefCount > 0 {
+			refs.keys[blob.Key] = true
+			continue
+		}
+		if blob.CreatedAt.After(cutoff) || refs.has(blob.Key) {
+			refs.keys[blob.Key] = true
+			continue
+		}
+		if *dryRun {
+			log.Printf("would forget unreferenced blob %s", blob.Key)
+			continue
+		}
+		_, err := cfg.db.DeleteUnreferencedBlob(blob.Key)
+		if err != nil {
+			return fmt.Errorf("couldn't delete blob %s: %w", blob.Key, err)
+		}
+	}
+
+	deleted := 0
+	for _, obj := range objects {
+		if refs.has(obj.Key) || obj.LastModified.After(cutoff) {
+			continue
+		}
+		if *dryRun {
+			log.Printf("would delete %s (%d bytes)", obj.Key, obj.Size)
+			deleted++
+			continue
+		}
+		err := cfg.storage.Delete(ctx, obj.Key)
+		if err != nil {
+			return fmt.Errorf("couldn't delete %s: %w", obj.Key, err)
+		}
+		deleted++
+	}
+
+	verb := "deleted"
+	if *dryRun {
+		verb = "would delete"
+	}
+	log.Printf("%s %d of %d objects", verb, deleted, len(objects))
+
+	return cfg.gcUploads(*dryRun, cutoff)
+}
+
+// gcUploads deletes resumable uploads that have expired, and files in the
+// uploads directory left behind by uploads that no longer exist, such as
+// those of deleted videos
+func (cfg *apiConfig) gcUploads(dryRun bool, cutoff time.Time) error {
+	uploads, err := cfg.db.GetUploads()
+	if err != nil {
+		return fmt.Errorf("couldn't list uploads: %w", err)
+	}
+
+	known := map[string]bool{}
+	expired := 0
+	for _, upload := range uploads {
+		known[upload.ID.String()] = true
+		if time.Now().Before(uploadExpires(&upload)) {
+			continue
+		}
+		// a single slow PATCH only saves its offset at the end, but keeps
+		// the file's modification time fresh
+		info, err := os.Stat(cfg.uploadPath(upload.ID))
+		if err == nil && time.Since(info.ModTime()) < tusUploadExpiry {
+			continue
+		}
+		expired++
+		if dryRun {
+			log.Printf("would delete expired upload %s (%d of %d bytes)", upload.ID, upload.Offset, upload.Length)
+			continue
+		}
+		err = cfg.removeUpload(upload.ID)
+		if err != nil {
+			return fmt.Errorf("couldn't delete upload %s: %w", upload.ID, err)
+		}
+		// only if nothing else has moved the video on since
+		err = cfg.db.SetVideoStatus(upload.VideoID, database.VideoFailed, "Upload expired")
+		if err != nil && !errors.Is(err, database.ErrInvalidTransition) {
+			return fmt.Errorf("couldn't update video %s: %w", upload.VideoID, err)
+		}
+	}
+
+	entries, err := os.ReadDir(cfg.uploadsRoot)
+	if err != nil {
+		return fmt.Errorf("couldn't list uploads directory: %w", err)
+	}
+	orphans := 0
+	for _, entry := range entries {
+		if known[entry.Name()] || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		orphans++
+		path := filepath.Join(cfg.uploadsRoot, entry.Name())
+		if dryRun {
+			log.Printf("would delete orphaned upload file %s (%d bytes)", path, info.Size())
+			continue
+		}
+		err = os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("couldn't delete %s: %w", path, err)
+		}
+	}
+
+	verb := "deleted"
+	if dryRun {
+		verb = "would delete"
+	}
+	log.Printf("%s %d expired uploads and %d orphaned upload files", verb, expired, orphans)
+	return nil
+}
+
+// storageRefs is what gc must keep
+type storageRefs struct {
+	keys     map[string]bool
+	prefixes []string
+	// urls we couldn't map to a key, e.g. from before a config change.
+	// Objects they end with are kept to be safe.
+	unknown []string
+}
+
+func (r storageRefs) has(key string) bool {
+	if r.keys[key] {
+		return true
+	}
+	for _, prefix := range r.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	for _, url := range r.unknown {
+		if strings.HasSuffix(url, "/"+key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg *apiConfig) storageReferences(videos []database.Video) storageRefs {
+	refs := storageRefs{keys: map[string]bool{}}
+	add := func(url *string) {
+		if url == nil || *url == "" || strings.HasPrefix(*url, "data:") {
+			return
+		}
+		if key, ok := cfg.storageKey(*url); ok {
+			refs.keys[key] = true
+			return
+		}
+		refs.unknown = append(refs.unknown, *url)
+	}
+
+	for _, video := range videos {
+		add(video.VideoURL)
+		add(video.ThumbnailURL)
+		add(video.HLSManifestURL)
+		add(video.PreviewVTTURL)
+		for _, url := range video.Thumbnails {
+			add(&url)
+		}
+		for _, url := range video.ThumbnailCandidates {
+			add(&url)
+		}
+		for _, track := range video.Captions {
+			add(&track.URL)
+		}
+		refs.prefixes = append(refs.prefixes, cfg.generatedPrefixes(video)...)
+	}
+	return refs
+}
+
+// generatedPrefixes are where the files generated for the video's current
+// file live. Output for files it had before is collected.
+func (cfg *apiConfig) generatedPrefixes(video database.Video) []string {
+	prefixes := []string{directUploadPrefix(video.ID)}
+	if video.VideoURL != nil {
+		// jobs for the file may still be running
+		prefixes = append(prefixes,
+			hlsPrefix(video.ID, *video.VideoURL),
+			previewPrefix(video.ID, *video.VideoURL),
+		)
+	}
+	for _, url := range []*string{video.HLSManifestURL, video.PreviewVTTURL} {
+		if url == nil {
+			continue
+		}
+		key, ok := cfg.storageKey(*url)
+		if !ok {
+			// can't tell which output it is, keep all of it
+			return append(prefixes, videoPrefixes(video.ID)...)
+		}
+		prefixes = append(prefixes, path.Dir(key)+"/")
+	}
+	return prefixes
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+func TestGCOnlyDeletesOwnPrefixes(t *testing.T) {
+	cfg := newTestConfig(t)
+	err := os.MkdirAll(cfg.uploadsRoot, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	ours := []string{
+		"landscape/abc.mp4",
+		"portrait/abc.mp4",
+		"other/abc.mp4",
+		"thumbnails/abc/480w.png",
+		"hls/abc/master.m3u8",
+		"previews/abc/sprite.jpg",
+		"captions/abc.vtt",
+		"uploads/abc/video.mp4",
+	}
+	foreign := []string{
+		"backups/tubely.db",
+		"landscape.mp4",
+		"index.html",
+	}
+	for _, key := range append(ours, foreign...) {
+		err := cfg.storage.Put(ctx, key, strings.NewReader("x"), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// with no grace every unreferenced object is fair game
+	err = cfg.runGC(ctx, []string{"-grace", "0"})
+	if err != nil {
+		t.Fatalf("runGC: %v", err)
+	}
+	for _, key := range ours {
+		if _, err := cfg.storage.Stat(ctx, key); !errors.Is(err, storage.ErrNotFound) {
+			t.Errorf("%s wasn't deleted: %v", key, err)
+		}
+	}
+	for _, key := range foreign {
+		if _, err := cfg.storage.Stat(ctx, key); err != nil {
+			t.Errorf("%s isn't tubely's but was deleted: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+func (cfg *apiConfig) handlerThumbnailGet(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil || video.ThumbnailURL == nil {
+		respondWithError(w, http.StatusNotFound, "Thumbnail not found", nil)
+		return
+	}
+
+	tn, err := cfg.loadThumbnail(r, *video.ThumbnailURL)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Thumbnail not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't load thumbnail", err)
+		return
+	}
+
+	w.Header().Set("Vary", "Accept")
+	if !acceptsMediaType(r.Header.Get("Accept"), tn.mediaType) {
+		respondWithError(w, http.StatusNotAcceptable, "Thumbnail is only available as "+tn.mediaType, nil)
+		return
+	}
+
+	sum := sha256.Sum256(tn.data)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	w.Header().Set("Content-Type", tn.mediaType)
+	// always revalidate, the ETag makes that cheap
+	w.Header().Set("Cache-Control", "no-cache")
+
+	// ServeContent answers If-None-Match and If-Modified-Since with a 304
+	http.ServeContent(w, r, "", video.UpdatedAt, bytes.NewReader(tn.data))
+}
+
+// loadThumbnail reads a thumbnail from either an inline data URL or storage
+func (cfg *apiConfig) loadThumbnail(r *http.Request, thumbnailURL string) (thumbnail, error) {
+	if strings.HasPrefix(thumbnailURL, "data:") {
+		data, mediaType, err := decodeDataURL(thumbnailURL)
+		if err != nil {
+			return thumbnail{}, err
+		}
+		return thumbnail{data: data, mediaType: mediaType}, nil
+	}
+
+	key, ok := cfg.storageKey(thumbnailURL)
+	if !ok {
+		return thumbnail{}, storage.ErrNotFound
+	}
+	body, info, err := cfg.storage.Get(r.Context(), key)
+	if err != nil {
+		return thumbnail{}, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return thumbnail{}, err
+	}
+	mediaType := info.ContentType
+	if mediaType == "" {
+		mediaType = mime.TypeByExtension(path.Ext(key))
+	}
+	return thumbnail{data: data, mediaType: mediaType}, nil
+}
+
+// acceptsMediaType reports whether an Accept header allows mediaType. An
+// empty header accepts anything.
+func acceptsMediaType(accept, mediaType string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+	mainType, _, _ := strings.Cut(mediaType, "/")
+	for _, item := range strings.Split(accept, ",") {
+		rangeType, params, err := mime.ParseMediaType(strings.TrimSpace(item))
+		if err != nil {
+			continue
+		}
+		if q, ok := params["q"]; ok {
+			weight, err := strconv.ParseFloat(q, 64)
+			if err != nil || weight <= 0 {
+				continue
+			}
+		}
+		if rangeType == "*/*" || rangeType == mediaType || rangeType == mainType+"/*" {
+			return true
+		}
+	}
+	return false
+}
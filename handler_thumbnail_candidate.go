@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// handlerThumbnailCandidateSelect makes one of the frames extracted from
+// the video its thumbnail
+func (cfg *apiConfig) handlerThumbnailCandidateSelect(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Candidate *int `json:"candidate"`
+	}
+
+	video, ok := cfg.getOwnVideo(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	if params.Candidate == nil || *params.Candidate < 0 || *params.Candidate >= len(video.ThumbnailCandidates) {
+		respondWithError(w, http.StatusBadRequest, "No such thumbnail candidate", nil)
+		return
+	}
+
+	key, ok := cfg.storageKey(video.ThumbnailCandidates[*params.Candidate])
+	if !ok {
+		respondWithError(w, http.StatusInternalServerError, "Thumbnail candidate isn't in storage", nil)
+		return
+	}
+	body, _, err := cfg.storage.Get(r.Context(), key)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read thumbnail candidate", err)
+		return
+	}
+	defer body.Close()
+	image, err := io.ReadAll(body)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read thumbnail candidate", err)
+		return
+	}
+
+	keys, err := cfg.saveThumbnail(r.Context(), &video, image)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to store thumbnail", err)
+		return
+	}
+	// a frame of the video, so the next upload may replace it with its own
+	video.CustomThumbnail = false
+	released, err := cfg.db.SetVideoThumbnail(video.ID, *video.ThumbnailURL, video.Thumbnails, false, keys)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to update Video Metadata", err)
+		return
+	}
+	cfg.deleteBlobs(released)
+
+	video, err = cfg.signVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, video)
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
+)
+
+// Resumable uploads following the tus 1.0 protocol (https://tus.io) with
+// the creation and termination extensions. Bytes are appended to a file in
+// cfg.uploadsRoot and the confirmed offset is kept in the uploads table, so
+// an upload can pick up where it left off after a restart. Uploads nothing
+// was appended to for tusUploadExpiry expire, and gc deletes them.
+
+const tusVersion = "1.0.0"
+
+const tusUploadExpiry = 24 * time.Hour
+
+// uploadLocks keeps two requests from writing to the same upload at once.
+// Upload files are local to this server, so an in-process lock is enough;
+// the offset is also compared-and-swapped in the database.
+type uploadLocks struct {
+	mu   sync.Mutex
+	held map[uuid.UUID]bool
+}
+
+func newUploadLocks() *uploadLocks {
+	return &uploadLocks{held: map[uuid.UUID]bool{}}
+}
+
+// tryLock takes the lock for an upload, or reports false if another
+// request has it
+func (l *uploadLocks) tryLock(id uuid.UUID) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held[id] {
+		return false
+	}
+	l.held[id] = true
+	return true
+}
+
+func (l *uploadLocks) unlock(id uuid.UUID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.held, id)
+}
+
+// uploadExpires is when an upload expires unless more is appended to it
+func uploadExpires(upload *database.Upload) time.Time {
+	return upload.UpdatedAt.Add(tusUploadExpiry)
+}
+
+func (cfg *apiConfig) handlerTusOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,termination,expiration")
+	w.Header().Set("Tus-Max-Size", strconv.Itoa(maxVideoSize))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusCreate(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not Own Video", nil)
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Length", err)
+		return
+	}
+	if length > maxVideoSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Video is too large", nil)
+		return
+	}
+
+	metadata := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if filetype, ok := metadata["filetype"]; ok && filetype != "video/mp4" {
+		respondWithError(w, http.StatusBadRequest, "Only MP4 videos are supported", nil)
+		return
+	}
+
+	err = cfg.setVideoStatus(&video, database.VideoUploading, "")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video status", err)
+		return
+	}
+
+	upload, err := cfg.db.CreateUpload(database.CreateUploadParams{
+		VideoID: videoID,
+		UserID:  userID,
+		Length:  length,
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload", err)
+		return
+	}
+
+	f, err := os.Create(cfg.uploadPath(upload.ID))
+	if err != nil {
+		// an upload without its file could never be appended to
+		if deleteErr := cfg.db.DeleteUpload(upload.ID); deleteErr != nil {
+			log.Printf("Couldn't delete upload %s: %v", upload.ID, deleteErr)
+		}
+		respondWithError(w, http.StatusInternalServerError, "Couldn't create upload file", err)
+		return
+	}
+	f.Close()
+
+	w.Header().Set("Location", "/api/tus/"+upload.ID.String())
+	w.Header().Set("Upload-Expires", uploadExpires(upload).UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (cfg *apiConfig) handlerTusHead(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	upload, ok := cfg.getOwnUpload(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Upload-Expires", uploadExpires(upload).UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (cfg *apiConfig) handlerTusPatch(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/offset+octet-stream", nil)
+		return
+	}
+	upload, ok := cfg.lockOwnUpload(w, r)
+	if !ok {
+		return
+	}
+	defer cfg.tusLocks.unlock(upload.ID)
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid Upload-Offset", err)
+		return
+	}
+	if offset != upload.Offset {
+		respondWithError(w, http.StatusConflict, "Upload-Offset doesn't match the current offset", nil)
+		return
+	}
+
+	f, err := os.OpenFile(cfg.uploadPath(upload.ID), os.O_WRONLY, 0644)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open upload file", err)
+		return
+	}
+	// drop anything written after the last confirmed offset, e.g. by a
+	// request that was cut off before the offset was saved
+	err = f.Truncate(upload.Offset)
+	if err == nil {
+		_, err = f.Seek(upload.Offset, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		respondWithError(w, http.StatusInternalServerError, "Couldn't prepare upload file", err)
+		return
+	}
+
+	// keep whatever arrived even if the client disconnects half way, that's
+	// what makes the upload resumable
+	written, copyErr := io.Copy(f, io.LimitReader(r.Body, upload.Length-upload.Offset))
+	closeErr := f.Close()
+	if closeErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't write upload file", closeErr)
+		return
+	}
+	saved, err := cfg.db.UpdateUploadOffset(upload.ID, upload.Offset, upload.Offset+written)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't save upload offset", err)
+		return
+	}
+	if !saved {
+		respondWithError(w, http.StatusConflict, "Upload was changed by another request", nil)
+		return
+	}
+	upload.Offset += written
+	upload.UpdatedAt = time.Now()
+	if copyErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Upload interrupted", copyErr)
+		return
+	}
+
+	if upload.Offset == upload.Length {
+		err = cfg.completeTusUpload(r, upload)
+		if errors.Is(err, database.ErrInvalidTransition) {
+			respondWithError(w, http.StatusConflict, "Video changed during upload", err)
+			return
+		}
+		if errors.Is(err, media.ErrInvalidVideo) {
+			respondWithError(w, http.StatusBadRequest, "Unable to read video metadata", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Unable to upload video", err)
+			return
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.Offset < upload.Length {
+		w.Header().Set("Upload-Expires", uploadExpires(upload).UTC().Format(http.TimeFormat))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerTusDelete(w http.ResponseWriter, r *http.Request) {
+	if !checkTusResumable(w, r) {
+		return
+	}
+	upload, ok := cfg.lockOwnUpload(w, r)
+	if !ok {
+		return
+	}
+	defer cfg.tusLocks.unlock(upload.ID)
+
+	err := cfg.removeUpload(upload.ID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't delete upload", err)
+		return
+	}
+	// only if nothing else has moved the video on since
+	err = cfg.db.SetVideoStatus(upload.VideoID, database.VideoFailed, "Upload cancelled")
+	if err != nil && !errors.Is(err, database.ErrInvalidTransition) {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video status", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// completeTusUpload hands a fully received upload to the normal video
+// pipeline and forgets about it. A file that isn't a usable video, or that
+// was overtaken by another upload, is discarded as well, resuming it would
+// never help.
+func (cfg *apiConfig) completeTusUpload(r *http.Request, upload *database.Upload) error {
+	video, err := cfg.db.GetVideo(upload.VideoID)
+	if err != nil {
+		return err
+	}
+	if video.ID == uuid.Nil {
+		cfg.removeUpload(upload.ID)
+		return fmt.Errorf("video %s no longer exists", upload.VideoID)
+	}
+
+	hash, err := fileSHA256(cfg.uploadPath(upload.ID))
+	if err != nil {
+		return err
+	}
+	err = cfg.finalizeVideoUpload(r.Context(), &video, cfg.uploadPath(upload.ID), hash)
+	if err != nil && !errors.Is(err, media.ErrInvalidVideo) && !errors.Is(err, database.ErrInvalidTransition) {
+		return err
+	}
+	if removeErr := cfg.removeUpload(upload.ID); removeErr != nil && err == nil {
+		return removeErr
+	}
+	return err
+}
+
+// getOwnUpload loads the upload named in the path and checks it belongs to
+// the caller. It writes the error response itself when it returns false.
+func (cfg *apiConfig) getOwnUpload(w http.ResponseWriter, r *http.Request) (*database.Upload, bool) {
+	uploadID, err := uuid.Parse(r.PathValue("uploadID"))
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Upload not found", err)
+		return nil, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return nil, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return nil, false
+	}
+
+	upload, err := cfg.db.GetUpload(uploadID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return nil, false
+	}
+	if upload == nil {
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return nil, false
+	}
+	if upload.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "Not Own Upload", nil)
+		return nil, false
+	}
+	if time.Now().After(uploadExpires(upload)) {
+		respondWithError(w, http.StatusGone, "Upload expired", nil)
+		return nil, false
+	}
+	return upload, true
+}
+
+// lockOwnUpload is getOwnUpload for requests that change the upload. It
+// takes the upload's lock and reloads it, as another request may have
+// moved it on while this one was waiting; the caller unlocks it.
+func (cfg *apiConfig) lockOwnUpload(w http.ResponseWriter, r *http.Request) (*database.Upload, bool) {
+	upload, ok := cfg.getOwnUpload(w, r)
+	if !ok {
+		return nil, false
+	}
+	id := upload.ID
+	if !cfg.tusLocks.tryLock(id) {
+		respondWithError(w, http.StatusConflict, "Upload is in use by another request", nil)
+		return nil, false
+	}
+	upload, err := cfg.db.GetUpload(id)
+	if err != nil {
+		cfg.tusLocks.unlock(id)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get upload", err)
+		return nil, false
+	}
+	if upload == nil {
+		cfg.tusLocks.unlock(id)
+		respondWithError(w, http.StatusNotFound, "Upload not found", nil)
+		return nil, false
+	}
+	return upload, true
+}
+
+func (cfg *apiConfig) uploadPath(id uuid.UUID) string {
+	return filepath.Join(cfg.uploadsRoot, id.String())
+}
+
+func (cfg *apiConfig) removeUpload(id uuid.UUID) error {
+	err := os.Remove(cfg.uploadPath(id))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return cfg.db.DeleteUpload(id)
+}
+
+// checkTusResumable sets the Tus-Resumable response header and rejects
+// clients speaking another protocol version
+func checkTusResumable(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		respondWithError(w, http.StatusPreconditionFailed, "Unsupported tus version", nil)
+		return false
+	}
+	return true
+}
+
+// parseTusMetadata decodes an Upload-Metadata header: comma separated
+// "key base64value" pairs where the value may be missing
+func parseTusMetadata(header string) map[string]string {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(value)
+	}
+	return metadata
+}
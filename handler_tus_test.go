@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/google/uuid"
+)
+
+// mkbox builds an MP4 box of the given type around its payload
+func mkbox(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	b = append(b, typ...)
+	return append(b, body...)
+}
+
+// testMP4 is the smallest file media.Probe reads as a 640x360, 5 second
+// H.264 video, with moov ahead of mdat so it's stored as is
+func testMP4() []byte {
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[40:], 0x10000)
+	binary.BigEndian.PutUint32(tkhd[56:], 0x10000)
+	binary.BigEndian.PutUint32(tkhd[72:], 0x40000000)
+	binary.BigEndian.PutUint32(tkhd[76:], 640<<16)
+	binary.BigEndian.PutUint32(tkhd[80:], 360<<16)
+	mdhd := make([]byte, 24)
+	binary.BigEndian.PutUint32(mdhd[12:], 1000)
+	binary.BigEndian.PutUint32(mdhd[16:], 5000)
+	hdlr := make([]byte, 25)
+	copy(hdlr[8:], "vide")
+	stsd := mkbox("stsd", make([]byte, 4), binary.BigEndian.AppendUint32(nil, 1), mkbox("avc1", make([]byte, 78)))
+
+	return bytes.Join([][]byte{
+		mkbox("ftyp", []byte("isom"), make([]byte, 4), []byte("isomavc1")),
+		mkbox("moov", mkbox("trak",
+			mkbox("tkhd", tkhd),
+			mkbox("mdia", mkbox("mdhd", mdhd), mkbox("hdlr", hdlr), mkbox("minf", mkbox("stbl", stsd))),
+		)),
+		mkbox("mdat", bytes.Repeat([]byte{0xAA}, 64)),
+	}, nil)
+}
+
+// createTusUpload starts an upload of length bytes for videoID and returns
+// its ID
+func createTusUpload(t *testing.T, cfg *apiConfig, videoID uuid.UUID, token string, length int) uuid.UUID {
+	t.Helper()
+	r := httptest.NewRequest("POST", "/api/videos/"+videoID.String()+"/tus", nil)
+	r.Header.Set("Tus-Resumable", tusVersion)
+	r.Header.Set("Upload-Length", strconv.Itoa(length))
+	res := serve(t, cfg.handlerTusCreate, "POST /api/videos/{videoID}/tus", r, token)
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("create: status %d, want 201", res.StatusCode)
+	}
+	id, err := uuid.Parse(strings.TrimPrefix(res.Header.Get("Location"), "/api/tus/"))
+	if err != nil {
+		t.Fatalf("create: Location %q", res.Header.Get("Location"))
+	}
+	return id
+}
+
+// patchTus appends body to an upload at offset
+func patchTus(t *testing.T, cfg *apiConfig, uploadID uuid.UUID, token string, offset int, body []byte) *http.Response {
+	t.Helper()
+	r := httptest.NewRequest("PATCH", "/api/tus/"+uploadID.String(), bytes.NewReader(body))
+	r.Header.Set("Tus-Resumable", tusVersion)
+	r.Header.Set("Content-Type", "application/offset+octet-stream")
+	r.Header.Set("Upload-Offset", strconv.Itoa(offset))
+	return serve(t, cfg.handlerTusPatch, "PATCH /api/tus/{uploadID}", r, token)
+}
+
+// newTusTest returns a config with its uploads directory in place and a
+// video owned by the returned token
+func newTusTest(t *testing.T) (*apiConfig, database.Video, string) {
+	t.Helper()
+	cfg := newTestConfig(t)
+	err := os.MkdirAll(cfg.uploadsRoot, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID, token := createTestUser(t, cfg)
+	return cfg, createTestVideo(t, cfg, userID), token
+}
+
+func TestTusCreate(t *testing.T) {
+	cfg, video, token := newTusTest(t)
+	id := createTusUpload(t, cfg, video.ID, token, 100)
+
+	upload, err := cfg.db.GetUpload(id)
+	if err != nil || upload == nil {
+		t.Fatalf("GetUpload = %v, %v", upload, err)
+	}
+	if upload.VideoID != video.ID || upload.Length != 100 || upload.Offset != 0 {
+		t.Fatalf("got %+v", upload)
+	}
+	stat, err := os.Stat(cfg.uploadPath(id))
+	if err != nil || stat.Size() != 0 {
+		t.Fatalf("upload file: %v, %v", stat, err)
+	}
+}
+
+func TestTusCreateRemovesUploadWithoutFile(t *testing.T) {
+	cfg, video, token := newTusTest(t)
+	// the upload file can't be created where there's no directory
+	cfg.uploadsRoot = filepath.Join(t.TempDir(), "missing")
+
+	r := httptest.NewRequest("POST", "/api/videos/"+video.ID.String()+"/tus", nil)
+	r.Header.Set("Tus-Resumable", tusVersion)
+	r.Header.Set("Upload-Length", "100")
+	res := serve(t, cfg.handlerTusCreate, "POST /api/videos/{videoID}/tus", r, token)
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("status %d, want 500", res.StatusCode)
+	}
+	uploads, err := cfg.db.GetUploads()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 0 {
+		t.Fatalf("%d uploads left without a file", len(uploads))
+	}
+}
+
+func TestTusPatchOffsetMismatch(t *testing.T) {
+	cfg, video, token := newTusTest(t)
+	id := createTusUpload(t, cfg, video.ID, token, 100)
+
+	res := patchTus(t, cfg, id, token, 0, bytes.Repeat([]byte{1}, 40))
+	if res.StatusCode != http.StatusNoContent || res.Header.Get("Upload-Offset") != "40" {
+		t.Fatalf("first patch: status %d at offset %s", res.StatusCode, res.Header.Get("Upload-Offset"))
+	}
+
+	// a client that missed the first patch, or repeats it, is told to
+	// ask for the offset again
+	for _, offset := range []int{0, 20, 60} {
+		res := patchTus(t, cfg, id, token, offset, bytes.Repeat([]byte{2}, 10))
+		if res.StatusCode != http.StatusConflict {
+			t.Fatalf("patch at %d: status %d, want 409", offset, res.StatusCode)
+		}
+	}
+	upload, err := cfg.db.GetUpload(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.Offset != 40 {
+		t.Fatalf("offset %d after refused patches, want 40", upload.Offset)
+	}
+	data, err := os.ReadFile(cfg.uploadPath(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, bytes.Repeat([]byte{1}, 40)) {
+		t.Fatalf("refused patches changed the file: %v", data)
+	}
+
+	r := httptest.NewRequest("HEAD", "/api/tus/"+id.String(), nil)
+	r.Header.Set("Tus-Resumable", tusVersion)
+	res = serve(t, cfg.handlerTusHead, "HEAD /api/tus/{uploadID}", r, token)
+	if res.Header.Get("Upload-Offset") != "40" || res.Header.Get("Upload-Length") != "100" {
+		t.Fatalf("HEAD reports %s of %s", res.Header.Get("Upload-Offset"), res.Header.Get("Upload-Length"))
+	}
+}
+
+func TestTusPatchOverrun(t *testing.T) {
+	cfg, video, token := newTusTest(t)
+	data := testMP4()
+	id := createTusUpload(t, cfg, video.ID, token, len(data))
+
+	res := patchTus(t, cfg, id, token, 0, data[:50])
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("first patch: status %d", res.StatusCode)
+	}
+	// bytes past Upload-Length are dropped, not stored with the video
+	res = patchTus(t, cfg, id, token, 50, append(append([]byte{}, data[50:]...), "trailing junk"...))
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("last patch: status %d, want 204", res.StatusCode)
+	}
+	if got := res.Header.Get("Upload-Offset"); got != strconv.Itoa(len(data)) {
+		t.Fatalf("Upload-Offset %s, want %d", got, len(data))
+	}
+
+	objects, err := cfg.storage.List(context.Background(), "landscape/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Size != int64(len(data)) {
+		t.Fatalf("stored %+v, want one %d byte video", objects, len(data))
+	}
+}
+
+func TestTusCompletion(t *testing.T) {
+	cfg, video, token := newTusTest(t)
+	data := testMP4()
+	id := createTusUpload(t, cfg, video.ID, token, len(data))
+
+	res := patchTus(t, cfg, id, token, 0, data)
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("status %d, want 204", res.StatusCode)
+	}
+	if res.Header.Get("Upload-Expires") != "" {
+		t.Fatal("a finished upload has an expiry")
+	}
+
+	// the upload is handed to the video and forgotten
+	upload, err := cfg.db.GetUpload(id)
+	if err != nil || upload != nil {
+		t.Fatalf("GetUpload = %+v, %v, want it gone", upload, err)
+	}
+	if _, err := os.Stat(cfg.uploadPath(id)); !os.IsNotExist(err) {
+		t.Fatalf("upload file left behind: %v", err)
+	}
+	stored, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.VideoURL == nil || !strings.HasPrefix(*stored.VideoURL, "/assets/landscape/") {
+		t.Fatalf("video URL %v", stored.VideoURL)
+	}
+	if *stored.Width != 640 || *stored.Height != 360 || *stored.Duration != 5 || *stored.Codec != "h264" {
+		t.Fatalf("stored %dx%d %gs %s", *stored.Width, *stored.Height, *stored.Duration, *stored.Codec)
+	}
+	// processing until transcoded, where ffmpeg is installed
+	want := database.VideoReady
+	if transcode.Available() {
+		want = database.VideoProcessing
+	}
+	if stored.Status != want {
+		t.Fatalf("status %s, want %s", stored.Status, want)
+	}
+
+	// there's nothing left to append to
+	res = patchTus(t, cfg, id, token, len(data), []byte{1})
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("patch after completion: status %d, want 404", res.StatusCode)
+	}
+}
+
+func TestTusCompletionInvalidVideo(t *testing.T) {
+	cfg, video, token := newTusTest(t)
+	id := createTusUpload(t, cfg, video.ID, token, 10)
+
+	res := patchTus(t, cfg, id, token, 0, []byte("not a mp4!"))
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400", res.StatusCode)
+	}
+	// resuming wouldn't help, so the upload is discarded
+	upload, err := cfg.db.GetUpload(id)
+	if err != nil || upload != nil {
+		t.Fatalf("GetUpload = %+v, %v, want it gone", upload, err)
+	}
+	if _, err := os.Stat(cfg.uploadPath(id)); !os.IsNotExist(err) {
+		t.Fatalf("upload file left behind: %v", err)
+	}
+	stored, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != database.VideoFailed || stored.VideoURL != nil {
+		t.Fatalf("video is %s with %v", stored.Status, stored.VideoURL)
+	}
+}
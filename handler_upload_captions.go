@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/captions"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// maxCaptionsSize is far more than a feature length film's subtitles need
+const maxCaptionsSize = 2 << 20
+
+// handlerUploadCaptions takes an SRT or WebVTT file and a BCP-47 language
+// tag, normalizes the file to WebVTT and adds it to the video's caption
+// tracks, replacing any earlier track for the same language
+func (cfg *apiConfig) handlerUploadCaptions(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCaptionsSize)
+	r.ParseMultipartForm(maxCaptionsSize)
+
+	// "captions" should match the HTML form input name
+	file, _, err := r.FormFile("captions")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
+		return
+	}
+	defer file.Close()
+
+	language, err := captions.Language(r.FormValue("language"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Language must be a BCP-47 tag such as en-US", err)
+		return
+	}
+	label := r.FormValue("label")
+	if label == "" {
+		label = language
+	}
+
+	videoDetail, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if videoDetail.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if videoDetail.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not Own Video", nil)
+		return
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "unable to read file", err)
+		return
+	}
+	cues, err := captions.Parse(data)
+	if errors.Is(err, captions.ErrInvalidCaptions) {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to read captions", err)
+		return
+	}
+	vtt := captions.WebVTT(cues)
+
+	// identical files are shared between tracks and videos
+	sum := sha256.Sum256(vtt)
+	hash := hex.EncodeToString(sum[:])
+	key, err := cfg.storeBlob(r.Context(), "captions/"+hash+".vtt", hash, bytes.NewReader(vtt), int64(len(vtt)), "text/vtt")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to store captions", err)
+		return
+	}
+
+	track := database.Caption{
+		Language: language,
+		Label:    label,
+		URL:      cfg.objectURL(key),
+	}
+	tracks, released, err := cfg.db.UpdateVideoCaptions(videoDetail.ID, func(current database.Captions) (database.Captions, []string) {
+		tracks := database.Captions{}
+		for _, existing := range current {
+			if existing.Language != language {
+				tracks = append(tracks, existing)
+			}
+		}
+		tracks = append(tracks, track)
+
+		keys := []string{}
+		for _, track := range tracks {
+			if trackKey, ok := cfg.storageKey(track.URL); ok {
+				keys = append(keys, trackKey)
+			}
+		}
+		return tracks, keys
+	})
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to update Video Metadata", err)
+		return
+	}
+	if tracks == nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	cfg.deleteBlobs(released)
+	videoDetail.Captions = tracks
+
+	videoDetail, err = cfg.signVideo(r.Context(), videoDetail)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, videoDetail)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mp4"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// presignedUploadExpiry is how long a direct upload URL stays valid
+const presignedUploadExpiry = 15 * time.Minute
+
+// handlerVideoUploadURL hands out a presigned PUT URL so the client can send
+// the video straight to the bucket instead of through the server
+func (cfg *apiConfig) handlerVideoUploadURL(w http.ResponseWriter, r *http.Request) {
+	type response struct {
+		UploadURL string            `json:"upload_url"`
+		Method    string            `json:"method"`
+		Headers   map[string]string `json:"headers"`
+		Key       string            `json:"key"`
+		ExpiresAt time.Time         `json:"expires_at"`
+	}
+
+	video, ok := cfg.getOwnVideo(w, r)
+	if !ok {
+		return
+	}
+
+	presigner, ok := cfg.storage.(storage.Presigner)
+	if !ok {
+		respondWithError(w, http.StatusNotImplemented, "Direct uploads need the S3 storage backend", nil)
+		return
+	}
+
+	name, err := randomFileKey(".mp4")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to create file key", err)
+		return
+	}
+	key := directUploadPrefix(video.ID) + name
+
+	expiresAt := time.Now().UTC().Add(presignedUploadExpiry)
+	uploadURL, err := presigner.PresignPut(r.Context(), key, "video/mp4", presignedUploadExpiry)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign upload URL", err)
+		return
+	}
+
+	err = cfg.setVideoStatus(&video, database.VideoUploading, "")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video status", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, response{
+		UploadURL: uploadURL,
+		Method:    http.MethodPut,
+		Headers:   map[string]string{"Content-Type": "video/mp4"},
+		Key:       key,
+		ExpiresAt: expiresAt,
+	})
+}
+
+// handlerVideoUploadComplete is called by the client once its direct
+// upload has finished. The object is checked with a HEAD request and by
+// reading the start of the file, then handed to a background job that
+// probes it and stores it like any other upload.
+func (cfg *apiConfig) handlerVideoUploadComplete(w http.ResponseWriter, r *http.Request) {
+	type parameters struct {
+		Key string `json:"key"`
+	}
+
+	video, ok := cfg.getOwnVideo(w, r)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Couldn't decode parameters", err)
+		return
+	}
+	// only accept keys handed out for this video
+	if !strings.HasPrefix(params.Key, directUploadPrefix(video.ID)) {
+		respondWithError(w, http.StatusBadRequest, "Key doesn't belong to this video", nil)
+		return
+	}
+
+	info, err := cfg.storage.Stat(r.Context(), params.Key)
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+		respondWithError(w, http.StatusBadRequest, "Uploaded video not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't check uploaded video", err)
+		return
+	}
+
+	err = cfg.setVideoStatus(&video, database.VideoProcessing, "")
+	if errors.Is(err, database.ErrInvalidTransition) {
+		respondWithError(w, http.StatusConflict, "Video isn't waiting for an upload", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't update video status", err)
+		return
+	}
+
+	// the presigned URL doesn't pin the Content-Type, so look at the bytes
+	// themselves
+	valid := info.Size > 0 && info.Size <= maxVideoSize
+	if valid {
+		valid, err = cfg.hasMP4Header(r.Context(), params.Key)
+		if err != nil {
+			cfg.failVideo(&video, err)
+			respondWithError(w, http.StatusInternalServerError, "Couldn't check uploaded video", err)
+			return
+		}
+	}
+	if !valid {
+		// nothing will ever reference it, don't leave it behind
+		cfg.storage.Delete(r.Context(), params.Key)
+		cfg.setVideoStatus(&video, database.VideoFailed, "Uploaded file must be an MP4 of at most 1GB")
+		respondWithError(w, http.StatusBadRequest, "Uploaded file must be an MP4 of at most 1GB", nil)
+		return
+	}
+
+	err = cfg.jobs.Enqueue(jobIngestUpload, ingestJob{VideoID: video.ID, Key: params.Key})
+	if err != nil {
+		cfg.failVideo(&video, err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't queue uploaded video", err)
+		return
+	}
+
+	video, err = cfg.signVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusAccepted, video)
+}
+
+// hasMP4Header reads the first bytes of the object at key and reports
+// whether they start an MP4
+func (cfg *apiConfig) hasMP4Header(ctx context.Context, key string) (bool, error) {
+	body, err := cfg.storage.GetRange(ctx, key, 0, 8)
+	if err != nil {
+		return false, err
+	}
+	defer body.Close()
+	head, err := io.ReadAll(io.LimitReader(body, 8))
+	if err != nil {
+		return false, err
+	}
+	return mp4.HasFileType(head), nil
+}
+
+// jobIngestUpload brings a direct upload into the video pipeline
+const jobIngestUpload = "ingest_upload"
+
+type ingestJob struct {
+	VideoID uuid.UUID `json:"video_id"`
+	Key     string    `json:"key"`
+}
+
+func (cfg *apiConfig) handleIngestUploadJob(ctx context.Context, payload json.RawMessage) error {
+	var job ingestJob
+	err := json.Unmarshal(payload, &job)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	err = cfg.ingestUpload(ctx, job)
+	if errors.Is(err, media.ErrInvalidVideo) || errors.Is(err, storage.ErrNotFound) {
+		return jobs.Permanent(err)
+	}
+	return err
+}
+
+// handleIngestUploadJobDead fails a video whose direct upload couldn't be
+// brought in
+func (cfg *apiConfig) handleIngestUploadJobDead(payload json.RawMessage, jobErr error) {
+	var job ingestJob
+	if json.Unmarshal(payload, &job) != nil {
+		return
+	}
+	reason := "Couldn't process video"
+	if errors.Is(jobErr, media.ErrInvalidVideo) {
+		reason = "Not a valid MP4 video"
+	}
+	err := cfg.db.SetVideoStatus(job.VideoID, database.VideoFailed, reason)
+	if err != nil && !errors.Is(err, database.ErrInvalidTransition) {
+		log.Printf("couldn't mark video %s failed: %v", job.VideoID, err)
+	}
+	cfg.storage.Delete(context.Background(), job.Key)
+}
+
+// ingestUpload downloads a direct upload and puts it through the same
+// probe, fast start and deduplication as uploads through the server. The
+// uploaded object is deleted once the video points at its stored copy.
+func (cfg *apiConfig) ingestUpload(ctx context.Context, job ingestJob) error {
+	video, err := cfg.db.GetVideo(job.VideoID)
+	if err != nil {
+		return err
+	}
+	// deleted, or another upload took over while waiting in the queue
+	if video.ID == uuid.Nil || video.Status != database.VideoProcessing {
+		return cfg.storage.Delete(ctx, job.Key)
+	}
+
+	path, cleanup, err := cfg.downloadToTemp(ctx, job.Key)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	hash, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+
+	err = cfg.storeVideoFile(ctx, &video, path, hash)
+	if err != nil {
+		return err
+	}
+	err = cfg.storage.Delete(ctx, job.Key)
+	if err != nil {
+		log.Printf("couldn't delete direct upload %s: %v", job.Key, err)
+	}
+	return cfg.processVideo(&video)
+}
+
+func directUploadPrefix(videoID uuid.UUID) string {
+	return "uploads/" + videoID.String() + "/"
+}
+
+// getOwnVideo loads the video named in the path and checks it belongs to
+// the caller. It writes the error response itself when it returns false.
+func (cfg *apiConfig) getOwnVideo(w http.ResponseWriter, r *http.Request) (database.Video, bool) {
+	videoID, err := uuid.Parse(r.PathValue("videoID"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return database.Video{}, false
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return database.Video{}, false
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return database.Video{}, false
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return database.Video{}, false
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return database.Video{}, false
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not Own Video", nil)
+		return database.Video{}, false
+	}
+	return video, true
+}
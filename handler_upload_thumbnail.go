@@ -1,16 +1,33 @@
 package main
 
 import (
-	"fmt"
-	"net/http"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
-
-	"encoding/base64"
+	"mime"
+	"net/http"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/imaging"
 	"github.com/google/uuid"
 )
 
+// thumbnailTypes are the media types accepted for thumbnail uploads
+var thumbnailTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// maxThumbnailSize caps the image of a thumbnail upload. Photos straight
+// from a phone are often over 10 MB; their pixel count is checked separately
+// by imaging.Resize.
+const maxThumbnailSize = 25 << 20
+
 func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -31,10 +48,34 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	fmt.Println("uploading thumbnail for video", videoID, "by user", userID)
-	//set up memory to store thumbnail
+	videoDetail, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if videoDetail.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if videoDetail.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "Not Own Video", nil)
+		return
+	}
+
+	//cap the whole request body, leaving room for the form around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxThumbnailSize+1<<20)
+	//set up memory to store thumbnail, anything bigger goes to a temp file
 	const maxMemory = 10 << 20
-	r.ParseMultipartForm(maxMemory)
+	err = r.ParseMultipartForm(maxMemory)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "Thumbnail is too large", err)
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Unable to parse form", err)
+		return
+	}
 
 	// "thumbnail" should match the HTML form input name
 	file, header, err := r.FormFile("thumbnail")
@@ -42,35 +83,86 @@ func (cfg *apiConfig) handlerUploadThumbnail(w http.ResponseWriter, r *http.Requ
 		respondWithError(w, http.StatusBadRequest, "Unable to parse form file", err)
 		return
 	}
-	mediaType := header.Header.Get("Content-Type")
 	defer file.Close()
+	if header.Size > maxThumbnailSize {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Thumbnail is too large", nil)
+		return
+	}
 
-	// `file` is an `io.Reader` that we can read from to get the image data
-	videoDetail, err := cfg.db.GetVideo(videoID)
+	mediaType, _, err := mime.ParseMediaType(header.Header.Get("Content-Type"))
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Video not found", err)
+		respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
 		return
 	}
-	if videoDetail.UserID != userID {
-		respondWithError(w, http.StatusUnauthorized, "Not Own Video", err)
+	if !thumbnailTypes[mediaType] {
+		respondWithError(w, http.StatusBadRequest, "Thumbnail must be a JPEG, PNG or WebP", nil)
 		return
 	}
+
+	// `file` is an `io.Reader` that we can read from to get the image data
 	image, err := io.ReadAll(file)
 	if err != nil {
 		respondWithError(w, http.StatusBadRequest, "unable to read file", err)
 		return
 	}
-	
-	//encode thumbnail in base64 to store in url field.
-	encodedThumbnail := base64.StdEncoding.EncodeToString(image)
-	thumbnailURL := fmt.Sprintf("data:%s;base64,%s", mediaType, encodedThumbnail)
-	videoDetail.ThumbnailURL = &thumbnailURL
 
-	err = cfg.db.UpdateVideo(videoDetail)
+	keys, err := cfg.saveThumbnail(r.Context(), &videoDetail, image)
+	if errors.Is(err, imaging.ErrInvalidImage) {
+		respondWithError(w, http.StatusBadRequest, "Unable to decode image", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to store thumbnail", err)
+		return
+	}
+	videoDetail.CustomThumbnail = true
+
+	released, err := cfg.db.SetVideoThumbnail(videoDetail.ID, *videoDetail.ThumbnailURL, videoDetail.Thumbnails, true, keys)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to update Video Metadata", err)
+		return
+	}
+	cfg.deleteBlobs(released)
+
+	videoDetail, err = cfg.signVideo(r.Context(), videoDetail)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Unable to update Video Metadata", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
 		return
 	}
 
 	respondWithJSON(w, http.StatusOK, videoDetail)
 }
+
+// saveThumbnail resizes the image into the standard renditions and stores
+// them under names derived from the source content, so uploading the same
+// image twice doesn't create new files. The largest rendition becomes the
+// video's ThumbnailURL. It returns the keys of the renditions for the
+// caller to save along with the thumbnail.
+func (cfg *apiConfig) saveThumbnail(ctx context.Context, video *database.Video, image []byte) ([]string, error) {
+	renditions, err := imaging.Resize(image, imaging.ThumbnailWidths)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(image)
+	prefix := "thumbnails/" + hex.EncodeToString(sum[:]) + "/"
+
+	urls := database.Thumbnails{}
+	keys := []string{}
+	largest := ""
+	for _, rendition := range renditions {
+		renditionSum := sha256.Sum256(rendition.Data)
+		key, err := cfg.storeBlob(ctx, prefix+rendition.Name()+rendition.Ext(), hex.EncodeToString(renditionSum[:]),
+			bytes.NewReader(rendition.Data), int64(len(rendition.Data)), rendition.MediaType)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		urls[rendition.Name()] = cfg.objectURL(key)
+		largest = urls[rendition.Name()]
+	}
+
+	video.Thumbnails = urls
+	video.ThumbnailURL = &largest
+	return keys, nil
+}
@@ -1,7 +1,166 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
 	"net/http"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/google/uuid"
 )
 
-func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {}
+// maxVideoSize caps every way of uploading a video
+const maxVideoSize = 1 << 30
+
+func (cfg *apiConfig) handlerUploadVideo(w http.ResponseWriter, r *http.Request) {
+	//cap the whole request body, not just the file part
+	r.Body = http.MaxBytesReader(w, r.Body, maxVideoSize)
+
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	videoDetail, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if videoDetail.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if videoDetail.UserID != userID {
+		respondWithError(w, http.StatusUnauthorized, "Not Own Video", nil)
+		return
+	}
+
+	// read the multipart body part by part so the video is never held in memory
+	reader, err := r.MultipartReader()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Unable to read multipart form", err)
+		return
+	}
+
+	tempFile, err := os.CreateTemp("", "tubely-upload-*.mp4")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to create temp file", err)
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	// hash while streaming so identical files are only stored once
+	hash := sha256.New()
+	found := false
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Unable to read multipart form", err)
+			return
+		}
+		// "video" should match the HTML form input name
+		if part.FormName() != "video" {
+			part.Close()
+			continue
+		}
+
+		mediaType, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid Content-Type", err)
+			return
+		}
+		if mediaType != "video/mp4" {
+			respondWithError(w, http.StatusBadRequest, "Only MP4 videos are supported", nil)
+			return
+		}
+
+		err = cfg.setVideoStatus(&videoDetail, database.VideoUploading, "")
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video status", err)
+			return
+		}
+
+		_, err = io.Copy(io.MultiWriter(tempFile, hash), part)
+		part.Close()
+		if err != nil {
+			cfg.failVideo(&videoDetail, err)
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				respondWithError(w, http.StatusRequestEntityTooLarge, "Video is too large", err)
+				return
+			}
+			respondWithError(w, http.StatusBadRequest, "Unable to read video", err)
+			return
+		}
+		found = true
+		break
+	}
+	if !found {
+		respondWithError(w, http.StatusBadRequest, "Missing video form file", nil)
+		return
+	}
+
+	err = tempFile.Close()
+	if err != nil {
+		cfg.failVideo(&videoDetail, err)
+		respondWithError(w, http.StatusInternalServerError, "Unable to save video", err)
+		return
+	}
+
+	err = cfg.finalizeVideoUpload(r.Context(), &videoDetail, tempFile.Name(), hex.EncodeToString(hash.Sum(nil)))
+	if errors.Is(err, database.ErrInvalidTransition) {
+		respondWithError(w, http.StatusConflict, "Video changed during upload", err)
+		return
+	}
+	if errors.Is(err, media.ErrInvalidVideo) {
+		respondWithError(w, http.StatusBadRequest, "Unable to read video metadata", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Unable to upload video", err)
+		return
+	}
+
+	videoDetail, err = cfg.signVideo(r.Context(), videoDetail)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, videoDetail)
+}
+
+// randomFileKey returns a random hex name with the given extension
+func randomFileKey(ext string) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b) + ext, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// handlerVideoMedia serves one of a video's locally stored files, such as
+// its HLS playlists and segments, caption tracks or preview sprites, to the
+// holder of a media token for the video. The token is a path segment, see
+// mediaURL.
+func (cfg *apiConfig) handlerVideoMedia(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	tokenVideoID, err := auth.ValidateMediaToken(r.PathValue("token"), cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate media token", err)
+		return
+	}
+	if tokenVideoID != videoID {
+		respondWithError(w, http.StatusUnauthorized, "Media token is for another video", nil)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	key := r.PathValue("key")
+	if !cfg.isVideoMediaKey(video, key) {
+		respondWithError(w, http.StatusNotFound, "File not found", nil)
+		return
+	}
+
+	body, info, err := cfg.storage.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+		respondWithError(w, http.StatusNotFound, "File not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open file", err)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", info.ContentType)
+	// the token in the URL is what expires, the bytes behind it don't
+	// change, and never do under a content-addressed name
+	if contentAddressed.MatchString(key) {
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "private, max-age=3600")
+	}
+	if content, ok := body.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, "", info.LastModified, content)
+		return
+	}
+	io.Copy(w, body)
+}
+
+// isVideoMediaKey reports whether key is a file handlerVideoMedia may serve
+// for video: its video file, one of its caption tracks, or a file next to
+// its HLS master playlist or preview track
+func (cfg *apiConfig) isVideoMediaKey(video database.Video, key string) bool {
+	files := []*string{video.VideoURL}
+	for i := range video.Captions {
+		files = append(files, &video.Captions[i].URL)
+	}
+	for _, url := range files {
+		if url == nil {
+			continue
+		}
+		if fileKey, ok := cfg.storageKey(*url); ok && fileKey == key {
+			return true
+		}
+	}
+
+	for _, url := range []*string{video.HLSManifestURL, video.PreviewVTTURL} {
+		if url == nil {
+			continue
+		}
+		if fileKey, ok := cfg.storageKey(*url); ok && strings.HasPrefix(key, path.Dir(fileKey)+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaURL is where handlerVideoMedia serves the file at key with token.
+// The token is in the path rather than the query string so the URLs a
+// playlist or preview track holds relative to it keep it.
+func mediaURL(videoID uuid.UUID, token, key string) string {
+	return "/api/videos/" + videoID.String() + "/media/" + token + "/" + key
+}
@@ -2,7 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
@@ -72,15 +80,199 @@ func (cfg *apiConfig) handlerVideoMetaDelete(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err = cfg.db.DeleteVideo(videoID)
+	released, err := cfg.db.DeleteVideo(videoID)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't delete video", err)
 		return
 	}
+	// shared files stay until the last video using them is gone
+	cfg.deleteVideoMedia(video, released)
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+const (
+	maxTitleLength       = 100
+	maxDescriptionLength = 5000
+)
+
+// handlerVideoMetaUpdate edits a video's title, description and is_public
+// with a JSON merge patch (RFC 7396): fields left out stay as they are and a null
+// description removes it. With If-Match, the edit only applies if the
+// video still has that ETag.
+func (cfg *apiConfig) handlerVideoMetaUpdate(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid ID", err)
+		return
+	}
+
+	token, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+		return
+	}
+	userID, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+	if video.UserID != userID {
+		respondWithError(w, http.StatusForbidden, "You can't edit this video", nil)
+		return
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || (mediaType != "application/merge-patch+json" && mediaType != "application/json") {
+		respondWithError(w, http.StatusUnsupportedMediaType, "Expected an application/merge-patch+json body", err)
+		return
+	}
+	var fields map[string]json.RawMessage
+	err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&fields)
+	if err != nil || fields == nil {
+		respondWithError(w, http.StatusBadRequest, "Body must be a JSON object", err)
+		return
+	}
+	patch, err := parseVideoMetaPatch(fields)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+
+	var ifUpdatedAt *time.Time
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" {
+		if !etagMatches(ifMatch, videoETag(video)) {
+			respondWithError(w, http.StatusPreconditionFailed, "Video was changed by someone else", nil)
+			return
+		}
+		ifUpdatedAt = &video.UpdatedAt
+	}
+
+	// an empty patch changes nothing, so the ETag stays valid
+	if len(fields) > 0 {
+		video, err = cfg.db.UpdateVideoMetadata(videoID, ifUpdatedAt, patch)
+		if errors.Is(err, database.ErrVideoModified) {
+			respondWithError(w, http.StatusPreconditionFailed, "Video was changed by someone else", err)
+			return
+		}
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't update video", err)
+			return
+		}
+		if video.ID == uuid.Nil {
+			respondWithError(w, http.StatusNotFound, "Video not found", nil)
+			return
+		}
+	}
+
+	video, err = cfg.signVideo(r.Context(), video)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+
+	w.Header().Set("ETag", videoETag(video))
+	respondWithJSON(w, http.StatusOK, video)
+}
+
+// parseVideoMetaPatch validates a merge patch and returns a function that
+// applies it
+func parseVideoMetaPatch(fields map[string]json.RawMessage) (func(*database.Video), error) {
+	var title *string
+	var description *string
+	clearDescription := false
+	var isPublic *bool
+
+	for name, raw := range fields {
+		isNull := string(raw) == "null"
+		switch name {
+		case "title":
+			if isNull {
+				return nil, errors.New("title can't be removed")
+			}
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return nil, errors.New("title must be a string")
+			}
+			s = strings.TrimSpace(s)
+			if s == "" {
+				return nil, errors.New("title can't be empty")
+			}
+			if utf8.RuneCountInString(s) > maxTitleLength {
+				return nil, fmt.Errorf("title can't be longer than %d characters", maxTitleLength)
+			}
+			title = &s
+		case "description":
+			if isNull {
+				clearDescription = true
+				continue
+			}
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return nil, errors.New("description must be a string or null")
+			}
+			if utf8.RuneCountInString(s) > maxDescriptionLength {
+				return nil, fmt.Errorf("description can't be longer than %d characters", maxDescriptionLength)
+			}
+			description = &s
+		case "is_public":
+			var b bool
+			if isNull || json.Unmarshal(raw, &b) != nil {
+				return nil, errors.New("is_public must be true or false")
+			}
+			isPublic = &b
+		default:
+			return nil, fmt.Errorf("%s can't be changed", name)
+		}
+	}
+
+	return func(video *database.Video) {
+		if title != nil {
+			video.Title = *title
+		}
+		if description != nil {
+			video.Description = *description
+		}
+		if clearDescription {
+			video.Description = ""
+		}
+		if isPublic != nil {
+			video.IsPublic = *isPublic
+		}
+	}, nil
+}
+
+// videoETag identifies a version of a video. Every change bumps
+// updated_at, so that is enough.
+func videoETag(video database.Video) string {
+	return `"` + strconv.FormatInt(video.UpdatedAt.UnixNano(), 36) + `"`
+}
+
+// etagMatches reports whether an If-Match header lists etag, or is "*"
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// handlerVideoGet is public, but only the owner gets URLs signed for
+// playback. Everyone else sees the stored references, which a private
+// bucket won't serve, and none for locally stored media.
 func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 	videoIDString := r.PathValue("videoID")
 	videoID, err := uuid.Parse(videoIDString)
@@ -89,12 +281,48 @@ func (cfg *apiConfig) handlerVideoGet(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// a token is optional, but one that was sent has to be valid
+	userID := uuid.Nil
+	if r.Header.Get("Authorization") != "" {
+		token, err := auth.GetBearerToken(r.Header)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't find JWT", err)
+			return
+		}
+		userID, err = auth.ValidateJWT(token, cfg.jwtSecret)
+		if err != nil {
+			respondWithError(w, http.StatusUnauthorized, "Couldn't validate JWT", err)
+			return
+		}
+	}
+
 	video, err := cfg.db.GetVideo(videoID)
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Couldn't get video", err)
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
 		return
 	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	if userID != uuid.Nil && video.UserID == userID {
+		err = cfg.setCloudFrontCookies(w, video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign CloudFront cookies", err)
+			return
+		}
 
+		video, err = cfg.signVideo(r.Context(), video)
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+			return
+		}
+	} else {
+		video = cfg.publicVideo(video)
+	}
+
+	w.Header().Set("ETag", videoETag(video))
 	respondWithJSON(w, http.StatusOK, video)
 }
 
@@ -110,11 +338,79 @@ func (cfg *apiConfig) handlerVideosRetrieve(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	videos, err := cfg.db.GetVideos(userID)
+	params, err := parseListVideosParams(r.URL.Query())
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
+	params.UserID = userID
+	page, err := cfg.db.ListVideos(params)
+	if errors.Is(err, database.ErrInvalidVideoQuery) {
+		respondWithError(w, http.StatusBadRequest, err.Error(), err)
+		return
+	}
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve videos", err)
 		return
 	}
 
-	respondWithJSON(w, http.StatusOK, videos)
+	videos, err := cfg.signVideos(r.Context(), page.Videos)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign video URLs", err)
+		return
+	}
+
+	type response struct {
+		Videos     []database.Video `json:"videos"`
+		NextCursor *string          `json:"next_cursor"`
+	}
+	resp := response{Videos: videos}
+	if page.NextCursor != "" {
+		resp.NextCursor = &page.NextCursor
+	}
+	respondWithJSON(w, http.StatusOK, resp)
+}
+
+// parseListVideosParams reads the query string of GET /api/videos, e.g.
+// ?limit=20&sort=title&status=ready,processing&created_after=2024-01-01T00:00:00Z
+func parseListVideosParams(query url.Values) (database.ListVideosParams, error) {
+	params := database.ListVideosParams{
+		Sort:   query.Get("sort"),
+		Order:  query.Get("order"),
+		Cursor: query.Get("cursor"),
+	}
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > database.MaxVideoPageSize {
+			return params, fmt.Errorf("limit must be between 1 and %d", database.MaxVideoPageSize)
+		}
+		params.Limit = limit
+	}
+	if s := query.Get("has_video"); s != "" {
+		hasVideo, err := strconv.ParseBool(s)
+		if err != nil {
+			return params, errors.New("has_video must be true or false")
+		}
+		params.HasVideo = &hasVideo
+	}
+	if s := query.Get("status"); s != "" {
+		for _, status := range strings.Split(s, ",") {
+			params.Statuses = append(params.Statuses, database.VideoStatus(strings.TrimSpace(status)))
+		}
+	}
+	for name, dest := range map[string]**time.Time{
+		"created_after":  &params.CreatedAfter,
+		"created_before": &params.CreatedBefore,
+	} {
+		s := query.Get(name)
+		if s == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return params, fmt.Errorf("%s must be an RFC 3339 time", name)
+		}
+		*dest = &t
+	}
+	return params, nil
 }
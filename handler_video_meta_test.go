@@ -0,0 +1,192 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+const videoMetaPattern = "PATCH /api/videos/{videoID}"
+
+// patchVideo sends a merge patch for videoID with the given If-Match
+// header, if any
+func patchVideo(t *testing.T, cfg *apiConfig, videoID uuid.UUID, token, ifMatch, body string) *http.Response {
+	t.Helper()
+	r := httptest.NewRequest("PATCH", "/api/videos/"+videoID.String(), strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/merge-patch+json")
+	if ifMatch != "" {
+		r.Header.Set("If-Match", ifMatch)
+	}
+	return serve(t, cfg.handlerVideoMetaUpdate, videoMetaPattern, r, token)
+}
+
+func TestVideoMetaUpdate(t *testing.T) {
+	tests := []struct {
+		name            string
+		body            string
+		wantTitle       string
+		wantDescription string
+		wantPublic      bool
+	}{
+		{"title", `{"title": "  Boots the bear  "}`, "Boots the bear", "A bear", false},
+		{"null description", `{"description": null}`, "Boots", "", false},
+		{"description and visibility", `{"description": "Big", "is_public": true}`, "Boots", "Big", true},
+		{"empty patch", `{}`, "Boots", "A bear", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			userID, token := createTestUser(t, cfg)
+			video := createTestVideo(t, cfg, userID)
+
+			// without If-Match the edit applies to whatever version is stored
+			res := patchVideo(t, cfg, video.ID, token, "", tt.body)
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("status %d, want 200", res.StatusCode)
+			}
+			var got database.Video
+			decodeBody(t, res, &got)
+			if got.Title != tt.wantTitle || got.Description != tt.wantDescription || got.IsPublic != tt.wantPublic {
+				t.Fatalf("got %q, %q, public %v", got.Title, got.Description, got.IsPublic)
+			}
+			stored, err := cfg.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.Title != tt.wantTitle || stored.Description != tt.wantDescription || stored.IsPublic != tt.wantPublic {
+				t.Fatalf("stored %q, %q, public %v", stored.Title, stored.Description, stored.IsPublic)
+			}
+			// the ETag is the stored version's, so it can be sent back
+			if etag := res.Header.Get("ETag"); etag != videoETag(stored) {
+				t.Fatalf("ETag %q, want %q", etag, videoETag(stored))
+			}
+		})
+	}
+}
+
+func TestVideoMetaUpdateRejects(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"null title", "application/merge-patch+json", `{"title": null}`, http.StatusBadRequest},
+		{"empty title", "application/merge-patch+json", `{"title": " "}`, http.StatusBadRequest},
+		{"title not a string", "application/merge-patch+json", `{"title": 1}`, http.StatusBadRequest},
+		{"unknown field", "application/merge-patch+json", `{"views": 10}`, http.StatusBadRequest},
+		{"null visibility", "application/merge-patch+json", `{"is_public": null}`, http.StatusBadRequest},
+		{"not an object", "application/merge-patch+json", `null`, http.StatusBadRequest},
+		{"not JSON", "application/merge-patch+json", `title=x`, http.StatusBadRequest},
+		{"form body", "application/x-www-form-urlencoded", `title=x`, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			userID, token := createTestUser(t, cfg)
+			video := createTestVideo(t, cfg, userID)
+
+			r := httptest.NewRequest("PATCH", "/api/videos/"+video.ID.String(), strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			res := serve(t, cfg.handlerVideoMetaUpdate, videoMetaPattern, r, token)
+			if res.StatusCode != tt.want {
+				t.Fatalf("status %d, want %d", res.StatusCode, tt.want)
+			}
+			stored, err := cfg.db.GetVideo(video.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !stored.UpdatedAt.Equal(video.UpdatedAt) {
+				t.Fatal("a rejected patch changed the video")
+			}
+		})
+	}
+}
+
+func TestVideoMetaUpdateIfMatch(t *testing.T) {
+	cfg := newTestConfig(t)
+	userID, token := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, userID)
+	etag := videoETag(video)
+
+	res := patchVideo(t, cfg, video.ID, token, etag, `{"title": "First"}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status %d with the current ETag, want 200", res.StatusCode)
+	}
+	newETag := res.Header.Get("ETag")
+	if newETag == "" || newETag == etag {
+		t.Fatalf("ETag %q after an edit, was %q", newETag, etag)
+	}
+
+	// an edit based on the old version is refused
+	res = patchVideo(t, cfg, video.ID, token, etag, `{"title": "Second"}`)
+	if res.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("status %d with a stale ETag, want 412", res.StatusCode)
+	}
+	res = patchVideo(t, cfg, video.ID, token, `"unknown"`, `{"title": "Second"}`)
+	if res.StatusCode != http.StatusPreconditionFailed {
+		t.Fatalf("status %d with an unknown ETag, want 412", res.StatusCode)
+	}
+	stored, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != "First" {
+		t.Fatalf("title %q after refused edits, want First", stored.Title)
+	}
+
+	// any of a list, or *, matches
+	res = patchVideo(t, cfg, video.ID, token, `"unknown", `+newETag, `{"title": "Third"}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status %d with the ETag in a list, want 200", res.StatusCode)
+	}
+	res = patchVideo(t, cfg, video.ID, token, "*", `{"title": "Fourth"}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status %d with *, want 200", res.StatusCode)
+	}
+}
+
+func TestVideoMetaUpdateAccess(t *testing.T) {
+	cfg := newTestConfig(t)
+	ownerID, _ := createTestUser(t, cfg)
+	_, otherToken := createTestUser(t, cfg)
+	video := createTestVideo(t, cfg, ownerID)
+
+	tests := []struct {
+		name        string
+		videoID     uuid.UUID
+		token       string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"no token", video.ID, "", "application/merge-patch+json", `{"title": "x"}`, http.StatusUnauthorized},
+		{"unknown video", uuid.New(), otherToken, "application/merge-patch+json", `{"title": "x"}`, http.StatusNotFound},
+		{"not the owner", video.ID, otherToken, "application/merge-patch+json", `{"title": "x"}`, http.StatusForbidden},
+		// ownership is checked before the body, so a non-owner learns
+		// nothing from how their patch would have been judged
+		{"not the owner, bad body", video.ID, otherToken, "application/merge-patch+json", `{"title": null}`, http.StatusForbidden},
+		{"not the owner, wrong media type", video.ID, otherToken, "text/plain", `x`, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("PATCH", "/api/videos/"+tt.videoID.String(), strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			res := serve(t, cfg.handlerVideoMetaUpdate, videoMetaPattern, r, tt.token)
+			if res.StatusCode != tt.want {
+				t.Fatalf("status %d, want %d", res.StatusCode, tt.want)
+			}
+		})
+	}
+	stored, err := cfg.db.GetVideo(video.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != "Boots" {
+		t.Fatalf("title %q, want it unchanged", stored.Title)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/google/uuid"
+)
+
+// maxPreviewTrackSize is far more than a track for the largest sprite sheet
+const maxPreviewTrackSize = 1 << 20
+
+// handlerVideoPreviewTrack serves a video's seek bar preview track with an
+// absolute, signed URL for its sprite sheet. The stored track refers to the
+// sheet relatively, which doesn't work once every object needs its own
+// signature. Access is the same as for streaming.
+func (cfg *apiConfig) handlerVideoPreviewTrack(w http.ResponseWriter, r *http.Request) {
+	videoIDString := r.PathValue("videoID")
+	videoID, err := uuid.Parse(videoIDString)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid video ID", err)
+		return
+	}
+
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't get video", err)
+		return
+	}
+	if video.ID == uuid.Nil {
+		respondWithError(w, http.StatusNotFound, "Video not found", nil)
+		return
+	}
+
+	err = cfg.checkStreamAccess(r, video)
+	if err != nil {
+		respondWithError(w, http.StatusUnauthorized, "Not allowed to view this video", err)
+		return
+	}
+
+	if video.PreviewVTTURL == nil {
+		respondWithError(w, http.StatusNotFound, "Video has no preview yet", nil)
+		return
+	}
+	key, ok := cfg.storageKey(*video.PreviewVTTURL)
+	if !ok {
+		respondWithError(w, http.StatusNotFound, "Preview track not found", nil)
+		return
+	}
+
+	body, _, err := cfg.storage.Get(r.Context(), key)
+	if errors.Is(err, storage.ErrNotFound) {
+		respondWithError(w, http.StatusNotFound, "Preview track not found", err)
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't open preview track", err)
+		return
+	}
+	defer body.Close()
+	track, err := io.ReadAll(io.LimitReader(body, maxPreviewTrackSize))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't read preview track", err)
+		return
+	}
+
+	spriteURL := cfg.objectURL(path.Dir(key) + "/" + transcode.SpriteImage)
+	signed, err := cfg.signURL(r.Context(), &spriteURL)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Couldn't sign sprite URL", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
+	// the signature in it expires
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Write(transcode.ResolveSpriteTrack(track, *signed))
+}
@@ -18,6 +18,8 @@ type TokenType string
 
 const (
 	TokenTypeAccess TokenType = "tubely-access"
+	// TokenTypeMedia grants streaming a single video, see MakeMediaToken
+	TokenTypeMedia TokenType = "tubely-media"
 )
 
 var ErrNoAuthHeaderIncluded = errors.New("no auth header included in request")
@@ -80,6 +82,54 @@ func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 	return id, nil
 }
 
+// MakeMediaToken signs a short-lived grant to stream one video. It goes in
+// URLs, where a <video> element can't send an Authorization header.
+func MakeMediaToken(
+	videoID uuid.UUID,
+	tokenSecret string,
+	expiresIn time.Duration,
+) (string, error) {
+	signingKey := []byte(tokenSecret)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Issuer:    string(TokenTypeMedia),
+		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+		Subject:   videoID.String(),
+	})
+	return token.SignedString(signingKey)
+}
+
+// ValidateMediaToken returns the video ID a media token grants access to
+func ValidateMediaToken(tokenString, tokenSecret string) (uuid.UUID, error) {
+	claimsStruct := jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(
+		tokenString,
+		&claimsStruct,
+		func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil },
+	)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	issuer, err := token.Claims.GetIssuer()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if issuer != string(TokenTypeMedia) {
+		return uuid.Nil, errors.New("invalid issuer")
+	}
+
+	videoIDString, err := token.Claims.GetSubject()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	id, err := uuid.Parse(videoIDString)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid video ID: %w", err)
+	}
+	return id, nil
+}
+
 func GetBearerToken(headers http.Header) (string, error) {
 	authHeader := headers.Get("Authorization")
 	if authHeader == "" {
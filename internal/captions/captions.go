@@ -0,0 +1,255 @@
+package captions
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/language"
+)
+
+var ErrInvalidCaptions = errors.New("invalid captions")
+
+// Cue is one caption: text shown from Start until End
+type Cue struct {
+	ID       string
+	Start    time.Duration
+	End      time.Duration
+	Settings string // WebVTT cue settings such as "line:0 align:start"
+	Text     string
+}
+
+// Parse reads SRT or WebVTT captions. WebVTT is recognised by its
+// "WEBVTT" header, anything else is treated as SRT.
+func Parse(data []byte) ([]Cue, error) {
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("%w: not UTF-8 text", ErrInvalidCaptions)
+	}
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	var cues []Cue
+	var err error
+	if isWebVTT(text) {
+		cues, err = parseWebVTT(text)
+	} else {
+		cues, err = parseSRT(text)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(cues) == 0 {
+		return nil, fmt.Errorf("%w: no cues", ErrInvalidCaptions)
+	}
+	return cues, nil
+}
+
+// WebVTT renders cues as a WebVTT file
+func WebVTT(cues []Cue) []byte {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for _, cue := range cues {
+		b.WriteString("\n")
+		if cue.ID != "" {
+			b.WriteString(cue.ID + "\n")
+		}
+		b.WriteString(timestamp(cue.Start) + " --> " + timestamp(cue.End))
+		if cue.Settings != "" {
+			b.WriteString(" " + cue.Settings)
+		}
+		b.WriteString("\n" + cue.Text + "\n")
+	}
+	return []byte(b.String())
+}
+
+// Language canonicalizes a BCP-47 tag, e.g. "EN-us" to "en-US"
+func Language(tag string) (string, error) {
+	t, err := language.Parse(strings.TrimSpace(tag))
+	if err != nil {
+		return "", fmt.Errorf("invalid language tag %q: %w", tag, err)
+	}
+	return t.String(), nil
+}
+
+func isWebVTT(text string) bool {
+	header, _, _ := strings.Cut(text, "\n")
+	return header == "WEBVTT" || strings.HasPrefix(header, "WEBVTT ") || strings.HasPrefix(header, "WEBVTT\t")
+}
+
+// blocks splits text on blank lines, keeping the line number each block
+// starts on for error messages
+type block struct {
+	line  int
+	lines []string
+}
+
+func blocks(text string) []block {
+	var result []block
+	var current *block
+	for i, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			current = nil
+			continue
+		}
+		if current == nil {
+			result = append(result, block{line: i + 1})
+			current = &result[len(result)-1]
+		}
+		current.lines = append(current.lines, line)
+	}
+	return result
+}
+
+var srtIndex = regexp.MustCompile(`^\d+$`)
+
+func parseSRT(text string) ([]Cue, error) {
+	var cues []Cue
+	for _, b := range blocks(text) {
+		lines := b.lines
+		line := b.line
+		if srtIndex.MatchString(strings.TrimSpace(lines[0])) {
+			lines = lines[1:]
+			line++
+		}
+		if len(lines) == 0 {
+			return nil, fmt.Errorf("%w: line %d: cue without timing", ErrInvalidCaptions, line)
+		}
+		// SRT has no cue settings, but some files carry coordinates after
+		// the end time, which are dropped
+		start, end, _, err := parseTiming(lines[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidCaptions, line, err)
+		}
+		cues = append(cues, Cue{
+			Start: start,
+			End:   end,
+			Text:  cleanText(lines[1:]),
+		})
+	}
+	return cues, nil
+}
+
+func parseWebVTT(text string) ([]Cue, error) {
+	var cues []Cue
+	// the first block is the header and anything after it on those lines
+	for _, b := range blocks(text)[1:] {
+		first := strings.TrimSpace(b.lines[0])
+		if first == "NOTE" || strings.HasPrefix(first, "NOTE ") ||
+			first == "STYLE" || first == "REGION" {
+			continue
+		}
+
+		lines := b.lines
+		line := b.line
+		id := ""
+		if !strings.Contains(lines[0], "-->") {
+			id = strings.TrimSpace(lines[0])
+			lines = lines[1:]
+			line++
+		}
+		if len(lines) == 0 {
+			return nil, fmt.Errorf("%w: line %d: cue without timing", ErrInvalidCaptions, line)
+		}
+		start, end, settings, err := parseTiming(lines[0])
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %v", ErrInvalidCaptions, line, err)
+		}
+		cues = append(cues, Cue{
+			ID:       id,
+			Start:    start,
+			End:      end,
+			Settings: settings,
+			Text:     cleanText(lines[1:]),
+		})
+	}
+	return cues, nil
+}
+
+// parseTiming reads "00:01:02,500 --> 00:01:04.000 settings..."
+func parseTiming(line string) (time.Duration, time.Duration, string, error) {
+	from, rest, ok := strings.Cut(line, "-->")
+	if !ok {
+		return 0, 0, "", fmt.Errorf("expected a timing line, got %q", line)
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return 0, 0, "", fmt.Errorf("missing end time in %q", line)
+	}
+	start, err := parseTimestamp(strings.TrimSpace(from))
+	if err != nil {
+		return 0, 0, "", err
+	}
+	end, err := parseTimestamp(fields[0])
+	if err != nil {
+		return 0, 0, "", err
+	}
+	if end <= start {
+		return 0, 0, "", fmt.Errorf("cue ends before it starts in %q", line)
+	}
+
+	var settings []string
+	for _, f := range fields[1:] {
+		// SRT's X1:.. Y2:.. coordinates aren't WebVTT settings
+		if name, _, ok := strings.Cut(f, ":"); ok && validSetting[name] {
+			settings = append(settings, f)
+		}
+	}
+	return start, end, strings.Join(settings, " "), nil
+}
+
+var validSetting = map[string]bool{
+	"vertical": true,
+	"line":     true,
+	"position": true,
+	"size":     true,
+	"align":    true,
+	"region":   true,
+}
+
+var timestampPattern = regexp.MustCompile(`^(?:(\d+):)?(\d{2}):(\d{2})[.,](\d{3})$`)
+
+// parseTimestamp accepts both "hh:mm:ss,ttt" (SRT) and "[hh:]mm:ss.ttt"
+// (WebVTT)
+func parseTimestamp(s string) (time.Duration, error) {
+	m := timestampPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	hours := 0
+	if m[1] != "" {
+		hours, _ = strconv.Atoi(m[1])
+	}
+	minutes, _ := strconv.Atoi(m[2])
+	seconds, _ := strconv.Atoi(m[3])
+	millis, _ := strconv.Atoi(m[4])
+	if minutes > 59 || seconds > 59 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return time.Duration(hours)*time.Hour +
+		time.Duration(minutes)*time.Minute +
+		time.Duration(seconds)*time.Second +
+		time.Duration(millis)*time.Millisecond, nil
+}
+
+func timestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3_600_000, ms/60_000%60, ms/1000%60, ms%1000)
+}
+
+// unsupportedMarkup is formatting some SRT editors emit that WebVTT players
+// would show as text: <font> tags and ASS style overrides like {\an8}
+var unsupportedMarkup = regexp.MustCompile(`(?i)</?font[^>]*>|\{\\[^}]*\}`)
+
+// cleanText joins cue lines, dropping markup WebVTT doesn't know and the
+// "-->" sequence, which isn't allowed in cue text
+func cleanText(lines []string) string {
+	text := strings.Join(lines, "\n")
+	text = unsupportedMarkup.ReplaceAllString(text, "")
+	return strings.ReplaceAll(text, "-->", "->")
+}
@@ -0,0 +1,158 @@
+package captions
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func ms(n int) time.Duration {
+	return time.Duration(n) * time.Millisecond
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Cue
+	}{
+		{
+			name:  "srt",
+			input: "1\n00:00:01,000 --> 00:00:02,500\nHello\nworld\n\n2\n00:01:00,000 --> 01:00:00,001\nBye\n",
+			want: []Cue{
+				{Start: ms(1000), End: ms(2500), Text: "Hello\nworld"},
+				{Start: time.Minute, End: time.Hour + ms(1), Text: "Bye"},
+			},
+		},
+		{
+			name:  "srt with BOM and CRLF",
+			input: "\ufeff1\r\n00:00:01,000 --> 00:00:02,000\r\nHello\r\n\r\n",
+			want:  []Cue{{Start: ms(1000), End: ms(2000), Text: "Hello"}},
+		},
+		{
+			name:  "srt without indexes",
+			input: "00:00:01,000 --> 00:00:02,000\nHello\n",
+			want:  []Cue{{Start: ms(1000), End: ms(2000), Text: "Hello"}},
+		},
+		{
+			name:  "srt coordinates and markup",
+			input: "1\n00:00:01,000 --> 00:00:02,000 X1:100 X2:200 Y1:10 Y2:20\n{\\an8}<font color=\"red\">Hi</font> <i>there</i> --> now\n",
+			want:  []Cue{{Start: ms(1000), End: ms(2000), Text: "Hi <i>there</i> -> now"}},
+		},
+		{
+			name: "webvtt",
+			input: "WEBVTT - some title\nKind: captions\n\n" +
+				"NOTE a comment\nover two lines\n\n" +
+				"STYLE\n::cue { color: red }\n\n" +
+				"REGION\nid:top width:40%\n\n" +
+				"intro\n00:01.000 --> 00:02.000 align:start line:0 X1:5 bogus:1\nHello\n\n" +
+				"01:00:00.000 --> 01:00:01.250\nLater\n",
+			want: []Cue{
+				{ID: "intro", Start: ms(1000), End: ms(2000), Settings: "align:start line:0", Text: "Hello"},
+				{Start: time.Hour, End: time.Hour + ms(1250), Text: "Later"},
+			},
+		},
+		{
+			name:  "webvtt with BOM and CR line endings",
+			input: "\ufeffWEBVTT\r\r00:00.500 --> 00:01.000\rHi\r",
+			want:  []Cue{{Start: ms(500), End: ms(1000), Text: "Hi"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse([]byte(tt.input))
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"empty", ""},
+		{"only whitespace", " \n\n \r\n"},
+		{"webvtt without cues", "WEBVTT\n\nNOTE nothing here\n"},
+		{"not UTF-8", "1\n00:00:01,000 --> 00:00:02,000\n\xff\xfe\n"},
+		{"index without timing", "1\n\n2\n00:00:01,000 --> 00:00:02,000\nHi\n"},
+		{"cue id without timing", "WEBVTT\n\nintro\n"},
+		{"text instead of timing", "1\nHello\n"},
+		{"missing end", "1\n00:00:01,000 -->\nHi\n"},
+		{"bad timestamp", "1\n00:00:01 --> 00:00:02,000\nHi\n"},
+		{"minutes out of range", "1\n00:60:00,000 --> 01:00:00,000\nHi\n"},
+		{"seconds out of range", "WEBVTT\n\n00:60.000 --> 01:00.000\nHi\n"},
+		{"ends before it starts", "1\n00:00:02,000 --> 00:00:01,000\nHi\n"},
+		{"zero length", "1\n00:00:01,000 --> 00:00:01,000\nHi\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cues, err := Parse([]byte(tt.input))
+			if !errors.Is(err, ErrInvalidCaptions) {
+				t.Fatalf("Parse = %+v, %v, want ErrInvalidCaptions", cues, err)
+			}
+		})
+	}
+}
+
+func TestWebVTT(t *testing.T) {
+	cues := []Cue{
+		{ID: "intro", Start: ms(1500), End: ms(3000), Settings: "align:start", Text: "Hello\nworld"},
+		{Start: time.Hour + 2*time.Minute + ms(3004), End: 10*time.Hour + ms(59999), Text: "Bye"},
+	}
+	want := "WEBVTT\n" +
+		"\nintro\n00:00:01.500 --> 00:00:03.000 align:start\nHello\nworld\n" +
+		"\n01:02:03.004 --> 10:00:59.999\nBye\n"
+	if got := string(WebVTT(cues)); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestSRTToWebVTT(t *testing.T) {
+	cues, err := Parse([]byte("1\r\n00:00:01,000 --> 00:00:02,000\r\n<font color=\"red\">Hi</font>\r\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHi\n"
+	if got := string(WebVTT(cues)); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+
+	// the output parses back to the same cues
+	again, err := Parse(WebVTT(cues))
+	if err != nil {
+		t.Fatalf("Parse of the output: %v", err)
+	}
+	if !reflect.DeepEqual(again, cues) {
+		t.Fatalf("round trip gave %+v, want %+v", again, cues)
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	tests := map[string]string{
+		"en":        "en",
+		"EN-us":     "en-US",
+		" pt-br ":   "pt-BR",
+		"zh-hant":   "zh-Hant",
+		"sr-latn-":  "",
+		"not a tag": "",
+	}
+	for tag, want := range tests {
+		got, err := Language(tag)
+		if want == "" {
+			if err == nil {
+				t.Errorf("Language(%q) = %q, want an error", tag, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("Language(%q) = %q, %v, want %q", tag, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package cloudfront
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Policy is a CloudFront custom policy. Resource may end in a "*" wildcard,
+// which is what signed cookies covering a whole distribution use.
+type Policy struct {
+	Resource  string
+	Expires   time.Time
+	NotBefore time.Time // optional
+	SourceIP  string    // optional CIDR, e.g. "203.0.113.0/24"
+}
+
+type epochTime struct {
+	EpochTime int64 `json:"AWS:EpochTime"`
+}
+
+type sourceIP struct {
+	SourceIP string `json:"AWS:SourceIp"`
+}
+
+type condition struct {
+	DateLessThan    epochTime  `json:"DateLessThan"`
+	DateGreaterThan *epochTime `json:"DateGreaterThan,omitempty"`
+	IPAddress       *sourceIP  `json:"IpAddress,omitempty"`
+}
+
+type statement struct {
+	Resource  string    `json:"Resource"`
+	Condition condition `json:"Condition"`
+}
+
+type policyDocument struct {
+	Statement []statement `json:"Statement"`
+}
+
+// JSON renders the policy the way CloudFront wants it, without whitespace
+func (p Policy) JSON() ([]byte, error) {
+	if p.Resource == "" {
+		return nil, errors.New("policy needs a resource")
+	}
+	if p.Expires.IsZero() {
+		return nil, errors.New("policy needs an expiry")
+	}
+	c := condition{
+		DateLessThan: epochTime{p.Expires.Unix()},
+	}
+	if !p.NotBefore.IsZero() {
+		c.DateGreaterThan = &epochTime{p.NotBefore.Unix()}
+	}
+	if p.SourceIP != "" {
+		c.IPAddress = &sourceIP{p.SourceIP}
+	}
+	return json.Marshal(policyDocument{
+		Statement: []statement{{Resource: p.Resource, Condition: c}},
+	})
+}
+
+// Signer signs CloudFront URLs and cookies with the private half of a key
+// registered with the distribution's trusted key group
+type Signer struct {
+	keyPairID string
+	key       *rsa.PrivateKey
+}
+
+func NewSigner(keyPairID string, key *rsa.PrivateKey) *Signer {
+	return &Signer{
+		keyPairID: keyPairID,
+		key:       key,
+	}
+}
+
+// ParsePrivateKey reads an RSA key from PEM, in either PKCS#1 or PKCS#8 form
+func ParsePrivateKey(pemData []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("CloudFront keys must be RSA")
+	}
+	return key, nil
+}
+
+// sign returns the encoded policy and its signature
+func (s *Signer) sign(policy Policy) (string, string, error) {
+	doc, err := policy.JSON()
+	if err != nil {
+		return "", "", err
+	}
+	// CloudFront only accepts SHA1 with RSA for these signatures
+	sum := sha1.Sum(doc)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA1, sum[:])
+	if err != nil {
+		return "", "", err
+	}
+	return encode(doc), encode(sig), nil
+}
+
+// SignURL appends the Policy, Signature and Key-Pair-Id query parameters
+func (s *Signer) SignURL(rawURL string, policy Policy) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	encodedPolicy, signature, err := s.sign(policy)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("Policy", encodedPolicy)
+	q.Set("Signature", signature)
+	q.Set("Key-Pair-Id", s.keyPairID)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Cookies returns the three CloudFront signed cookies for policy. The
+// caller sets Domain and Path to match the distribution.
+func (s *Signer) Cookies(policy Policy) ([]*http.Cookie, error) {
+	encodedPolicy, signature, err := s.sign(policy)
+	if err != nil {
+		return nil, err
+	}
+	values := []struct{ name, value string }{
+		{"CloudFront-Policy", encodedPolicy},
+		{"CloudFront-Signature", signature},
+		{"CloudFront-Key-Pair-Id", s.keyPairID},
+	}
+	cookies := make([]*http.Cookie, 0, len(values))
+	for _, v := range values {
+		cookies = append(cookies, &http.Cookie{
+			Name:     v.name,
+			Value:    v.value,
+			Expires:  policy.Expires,
+			Secure:   true,
+			HttpOnly: true,
+		})
+	}
+	return cookies, nil
+}
+
+// encode is base64 with the characters CloudFront can't take in a query
+// string or cookie swapped out
+func encode(b []byte) string {
+	return strings.NewReplacer("+", "-", "=", "_", "/", "~").Replace(base64.StdEncoding.EncodeToString(b))
+}
@@ -0,0 +1,191 @@
+package cloudfront
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testdata/key.pem is a throwaway key, and key-pkcs8.pem the same key in
+// PKCS#8 form. The expected values below were made with openssl:
+//
+//	openssl dgst -sha1 -sign testdata/key.pem policy.json | base64 -w0 | tr '+=/' '-_~'
+const (
+	testResource = "https://d111111abcdef8.cloudfront.net/videos/clip.mp4"
+	testExpires  = 1767225600 // 2026-01-01T00:00:00Z
+
+	cannedPolicy        = `{"Statement":[{"Resource":"https://d111111abcdef8.cloudfront.net/videos/clip.mp4","Condition":{"DateLessThan":{"AWS:EpochTime":1767225600}}}]}`
+	cannedPolicyEncoded = "eyJTdGF0ZW1lbnQiOlt7IlJlc291cmNlIjoiaHR0cHM6Ly9kMTExMTExYWJjZGVmOC5jbG91ZGZyb250Lm5ldC92aWRlb3MvY2xpcC5tcDQiLCJDb25kaXRpb24iOnsiRGF0ZUxlc3NUaGFuIjp7IkFXUzpFcG9jaFRpbWUiOjE3NjcyMjU2MDB9fX1dfQ__"
+	cannedSignature     = "WQpxcyU78KgPBEO5tpxSmBEQckA6ojOV3pqALq17HcM6QBpAALYhOPJfYWkzN0eLAFPviHsDLLzli2MiyQDc4LtrZq5kxOhoPZS~V4rglN9ab-65b2DTd1Rub8V0pjGnvqvhnaS7O7NjRMiy~qlXjY1~SbIJX-Iz5TqQIZiTsg7rRH0WHJfpHMhf47i--Uwak8OD~Zw563ZtxGOGZX0oEtE0VsaaEyZlE3SsZ3Upou6V1QJmQ31k6PCuMT0aTNKwDWHxslgs9Pn4IqDZEdhK9QCC6BLwfuh6c8g9XHUeR7~vVy7XokfQdU-VPE5j5WDd7NZ54~w2dq0~ONx6J6Zaew__"
+)
+
+func testSigner(t *testing.T) *Signer {
+	t.Helper()
+	pemData, err := os.ReadFile("testdata/key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParsePrivateKey(pemData)
+	if err != nil {
+		t.Fatalf("ParsePrivateKey: %v", err)
+	}
+	return NewSigner("K2JCJMDEHXQW5F", key)
+}
+
+// decode undoes encode
+func decode(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.StdEncoding.DecodeString(strings.NewReplacer("-", "+", "_", "=", "~", "/").Replace(s))
+	if err != nil {
+		t.Fatalf("decoding %q: %v", s, err)
+	}
+	return b
+}
+
+func TestPolicyJSONCanned(t *testing.T) {
+	// a policy with only a resource and an expiry is CloudFront's canned
+	// policy statement
+	doc, err := Policy{Resource: testResource, Expires: time.Unix(testExpires, 0)}.JSON()
+	if err != nil {
+		t.Fatalf("JSON: %v", err)
+	}
+	if string(doc) != cannedPolicy {
+		t.Fatalf("got  %s\nwant %s", doc, cannedPolicy)
+	}
+}
+
+func TestPolicyJSONCustom(t *testing.T) {
+	doc, err := Policy{
+		Resource:  "https://d111111abcdef8.cloudfront.net/hls/abc/*",
+		Expires:   time.Unix(testExpires, 0),
+		NotBefore: time.Unix(testExpires-3600, 0),
+		SourceIP:  "203.0.113.0/24",
+	}.JSON()
+	if err != nil {
+		t.Fatalf("JSON: %v", err)
+	}
+	want := `{"Statement":[{"Resource":"https://d111111abcdef8.cloudfront.net/hls/abc/*","Condition":{"DateLessThan":{"AWS:EpochTime":1767225600},"DateGreaterThan":{"AWS:EpochTime":1767222000},"IpAddress":{"AWS:SourceIp":"203.0.113.0/24"}}}]}`
+	if string(doc) != want {
+		t.Fatalf("got  %s\nwant %s", doc, want)
+	}
+}
+
+func TestPolicyJSONRequiresResourceAndExpiry(t *testing.T) {
+	if _, err := (Policy{Expires: time.Unix(testExpires, 0)}).JSON(); err == nil {
+		t.Error("expected an error without a resource")
+	}
+	if _, err := (Policy{Resource: testResource}).JSON(); err == nil {
+		t.Error("expected an error without an expiry")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	// 0xfb 0xff encodes to "+/8=" in standard base64
+	if got := encode([]byte{0xfb, 0xff}); got != "-~8_" {
+		t.Fatalf("encode = %q, want %q", got, "-~8_")
+	}
+}
+
+func TestSign(t *testing.T) {
+	encodedPolicy, signature, err := testSigner(t).sign(Policy{Resource: testResource, Expires: time.Unix(testExpires, 0)})
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if encodedPolicy != cannedPolicyEncoded {
+		t.Errorf("policy = %s, want %s", encodedPolicy, cannedPolicyEncoded)
+	}
+	if signature != cannedSignature {
+		t.Errorf("signature = %s, want %s", signature, cannedSignature)
+	}
+}
+
+func TestSignURL(t *testing.T) {
+	signer := testSigner(t)
+	policy := Policy{
+		Resource: testResource,
+		Expires:  time.Unix(testExpires, 0),
+		SourceIP: "203.0.113.0/24",
+	}
+	signed, err := signer.SignURL(testResource+"?quality=720", policy)
+	if err != nil {
+		t.Fatalf("SignURL: %v", err)
+	}
+
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(signed, testResource+"?") {
+		t.Errorf("signed URL %s doesn't start with the resource", signed)
+	}
+	q := u.Query()
+	if q.Get("quality") != "720" {
+		t.Errorf("existing query parameter was lost: %s", signed)
+	}
+	if q.Get("Key-Pair-Id") != "K2JCJMDEHXQW5F" {
+		t.Errorf("Key-Pair-Id = %q", q.Get("Key-Pair-Id"))
+	}
+
+	doc := decode(t, q.Get("Policy"))
+	want, _ := policy.JSON()
+	if string(doc) != string(want) {
+		t.Errorf("Policy decodes to %s, want %s", doc, want)
+	}
+	sum := sha1.Sum(doc)
+	err = rsa.VerifyPKCS1v15(&signer.key.PublicKey, crypto.SHA1, sum[:], decode(t, q.Get("Signature")))
+	if err != nil {
+		t.Errorf("signature doesn't verify: %v", err)
+	}
+}
+
+func TestCookies(t *testing.T) {
+	policy := Policy{Resource: testResource, Expires: time.Unix(testExpires, 0)}
+	cookies, err := testSigner(t).Cookies(policy)
+	if err != nil {
+		t.Fatalf("Cookies: %v", err)
+	}
+
+	want := map[string]string{
+		"CloudFront-Policy":      cannedPolicyEncoded,
+		"CloudFront-Signature":   cannedSignature,
+		"CloudFront-Key-Pair-Id": "K2JCJMDEHXQW5F",
+	}
+	if len(cookies) != len(want) {
+		t.Fatalf("got %d cookies, want %d", len(cookies), len(want))
+	}
+	for _, cookie := range cookies {
+		if cookie.Value != want[cookie.Name] {
+			t.Errorf("%s = %q, want %q", cookie.Name, cookie.Value, want[cookie.Name])
+		}
+		if !cookie.Secure || !cookie.HttpOnly {
+			t.Errorf("%s should be Secure and HttpOnly", cookie.Name)
+		}
+		if !cookie.Expires.Equal(policy.Expires) {
+			t.Errorf("%s expires %v, want %v", cookie.Name, cookie.Expires, policy.Expires)
+		}
+	}
+}
+
+func TestParsePrivateKeyPKCS8(t *testing.T) {
+	pemData, err := os.ReadFile("testdata/key-pkcs8.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParsePrivateKey(pemData)
+	if err != nil {
+		t.Fatalf("ParsePrivateKey: %v", err)
+	}
+	if !key.Equal(testSigner(t).key) {
+		t.Fatal("PKCS#8 and PKCS#1 files parsed to different keys")
+	}
+
+	if _, err := ParsePrivateKey([]byte("not a key")); err == nil {
+		t.Fatal("expected an error for data without PEM")
+	}
+}
@@ -0,0 +1,273 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Blob roles say what a video uses a blob for
+const (
+	BlobRoleVideo              = "video"
+	BlobRoleThumbnail          = "thumbnail"
+	BlobRoleThumbnailCandidate = "thumbnail_candidate"
+	BlobRoleCaptions           = "captions"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+// Blob is an object in storage that videos can share. Hash is the hex
+// SHA-256 of the stored content, which for videos is the fast start file
+// rather than the upload. It is nil for objects the server never saw the
+// bytes of. RefCount is the number of video references.
+type Blob struct {
+	Key         string    `json:"key"`
+	Hash        *string   `json:"hash"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	RefCount    int       `json:"ref_count"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+const blobColumns = `
+		key,
+		hash,
+		size,
+		content_type,
+		ref_count,
+		created_at`
+
+func scanBlob(row scanner) (Blob, error) {
+	var blob Blob
+	err := row.Scan(
+		&blob.Key,
+		&blob.Hash,
+		&blob.Size,
+		&blob.ContentType,
+		&blob.RefCount,
+		&blob.CreatedAt,
+	)
+	return blob, err
+}
+
+// CreateBlob records a stored object with no references. If another blob
+// with the same hash got there first nothing is written; callers look the
+// hash up again to find out which key won.
+func (c Client) CreateBlob(blob Blob) error {
+	query := `
+	INSERT INTO blobs (
+		key,
+		hash,
+		size,
+		content_type,
+		ref_count,
+		created_at
+	) VALUES (?, ?, ?, ?, 0, CURRENT_TIMESTAMP)
+	ON CONFLICT DO NOTHING
+	`
+	_, err := c.db.Exec(query, blob.Key, blob.Hash, blob.Size, blob.ContentType)
+	return err
+}
+
+// GetBlob returns nil if there is no blob at key
+func (c Client) GetBlob(key string) (*Blob, error) {
+	query := `
+	SELECT` + blobColumns + `
+	FROM blobs
+	WHERE key = ?
+	`
+	blob, err := scanBlob(c.db.QueryRow(query, key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &blob, nil
+}
+
+// GetBlobs returns every blob
+func (c Client) GetBlobs() ([]Blob, error) {
+	query := `
+	SELECT` + blobColumns + `
+	FROM blobs
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blobs := []Blob{}
+	for rows.Next() {
+		blob, err := scanBlob(rows)
+		if err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs, rows.Err()
+}
+
+// DeleteUnreferencedBlob removes a blob row nothing ever attached, such as
+// one left by an upload that failed halfway. It reports whether it did.
+func (c Client) DeleteUnreferencedBlob(key string) (bool, error) {
+	result, err := c.db.Exec("DELETE FROM blobs WHERE key = ? AND ref_count = 0", key)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetBlobByHash returns nil if no blob has the hash
+func (c Client) GetBlobByHash(hash string) (*Blob, error) {
+	query := `
+	SELECT` + blobColumns + `
+	FROM blobs
+	WHERE hash = ?
+	`
+	blob, err := scanBlob(c.db.QueryRow(query, hash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &blob, nil
+}
+
+// SetVideoBlobs makes keys the blobs a video uses for role, replacing the
+// previous ones. It returns the blobs no video references anymore; their
+// rows are gone and the caller should delete the objects.
+func (c Client) SetVideoBlobs(videoID uuid.UUID, role string, keys []string) ([]Blob, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	released, err := setVideoBlobs(tx, videoID, role, keys)
+	if err != nil {
+		return nil, err
+	}
+	return released, tx.Commit()
+}
+
+// setVideoBlobs is SetVideoBlobs inside tx, for setters that change the
+// columns referencing the blobs in the same transaction
+func setVideoBlobs(tx dbTx, videoID uuid.UUID, role string, keys []string) ([]Blob, error) {
+	// add the new references first so a key that stays doesn't briefly
+	// drop to zero
+	for _, key := range keys {
+		result, err := tx.Exec(`
+		INSERT INTO video_blobs (video_id, blob_key, role)
+		SELECT CAST(? AS TEXT), key, CAST(? AS TEXT) FROM blobs WHERE key = ?
+		ON CONFLICT DO NOTHING
+		`, videoID, role, key)
+		if err != nil {
+			return nil, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			// either already referenced, or the blob doesn't exist
+			var exists bool
+			err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM blobs WHERE key = ?)", key).Scan(&exists)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				return nil, fmt.Errorf("%w: %s", ErrBlobNotFound, key)
+			}
+			continue
+		}
+		_, err = tx.Exec("UPDATE blobs SET ref_count = ref_count + 1 WHERE key = ?", key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	keep := map[string]bool{}
+	for _, key := range keys {
+		keep[key] = true
+	}
+	current, err := videoBlobKeys(tx, videoID, role)
+	if err != nil {
+		return nil, err
+	}
+	var dropped []string
+	for _, key := range current {
+		if !keep[key] {
+			dropped = append(dropped, key)
+		}
+	}
+
+	return releaseVideoBlobs(tx, videoID, role, dropped)
+}
+
+// GetVideoBlobs returns the keys of every blob a video references
+func (c Client) GetVideoBlobs(videoID uuid.UUID) ([]string, error) {
+	rows, err := c.db.Query("SELECT blob_key FROM video_blobs WHERE video_id = ?", videoID)
+	if err != nil {
+		return nil, err
+	}
+	return scanKeys(rows)
+}
+
+func videoBlobKeys(tx dbTx, videoID uuid.UUID, role string) ([]string, error) {
+	rows, err := tx.Query("SELECT blob_key FROM video_blobs WHERE video_id = ? AND role = ?", videoID, role)
+	if err != nil {
+		return nil, err
+	}
+	return scanKeys(rows)
+}
+
+func scanKeys(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// releaseVideoBlobs drops the video's references to keys under role and
+// deletes, and returns, the blobs left without any
+func releaseVideoBlobs(tx dbTx, videoID uuid.UUID, role string, keys []string) ([]Blob, error) {
+	released := []Blob{}
+	for _, key := range keys {
+		_, err := tx.Exec("DELETE FROM video_blobs WHERE video_id = ? AND blob_key = ? AND role = ?", videoID, key, role)
+		if err != nil {
+			return nil, err
+		}
+		blob, err := scanBlob(tx.QueryRow(`
+		UPDATE blobs SET ref_count = ref_count - 1
+		WHERE key = ?
+		RETURNING`+blobColumns, key))
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if blob.RefCount > 0 {
+			continue
+		}
+		_, err = tx.Exec("DELETE FROM blobs WHERE key = ?", key)
+		if err != nil {
+			return nil, err
+		}
+		released = append(released, blob)
+	}
+	return released, nil
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCreateBlobKeepsFirstOfHash(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		hash := "0123abcd"
+		for _, key := range []string{"videos/first.mp4", "videos/second.mp4"} {
+			err := c.CreateBlob(Blob{Key: key, Hash: &hash, Size: 10, ContentType: "video/mp4"})
+			if err != nil {
+				t.Fatalf("CreateBlob(%s): %v", key, err)
+			}
+		}
+
+		blob, err := c.GetBlobByHash(hash)
+		if err != nil {
+			t.Fatalf("GetBlobByHash: %v", err)
+		}
+		if blob == nil || blob.Key != "videos/first.mp4" {
+			t.Fatalf("GetBlobByHash = %+v, want the first key", blob)
+		}
+		blob, err = c.GetBlob("videos/second.mp4")
+		if err != nil || blob != nil {
+			t.Fatalf("GetBlob(second) = %+v, %v, want nothing written", blob, err)
+		}
+	})
+}
+
+func TestSetVideoBlobsRefCounts(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		userID := createUser(t, c, "a@example.com")
+		first := createVideo(t, c, userID, "first", "")
+		second := createVideo(t, c, userID, "second", "")
+		createBlob(t, c, "shared.jpg")
+		createBlob(t, c, "own.jpg")
+
+		setBlobs := func(videoID Video, keys ...string) []Blob {
+			t.Helper()
+			released, err := c.SetVideoBlobs(videoID.ID, BlobRoleThumbnail, keys)
+			if err != nil {
+				t.Fatalf("SetVideoBlobs(%s, %v): %v", videoID.Title, keys, err)
+			}
+			return released
+		}
+		refCount := func(key string) int {
+			t.Helper()
+			blob, err := c.GetBlob(key)
+			if err != nil {
+				t.Fatalf("GetBlob(%s): %v", key, err)
+			}
+			if blob == nil {
+				return 0
+			}
+			return blob.RefCount
+		}
+
+		setBlobs(first, "shared.jpg", "own.jpg")
+		setBlobs(second, "shared.jpg")
+		// setting the same keys again adds no references
+		setBlobs(second, "shared.jpg")
+		if n := refCount("shared.jpg"); n != 2 {
+			t.Fatalf("shared.jpg has %d references, want 2", n)
+		}
+
+		if released := setBlobs(first, "own.jpg"); len(released) != 0 {
+			t.Fatalf("released %+v while the second video still uses it", released)
+		}
+		released := setBlobs(second)
+		if len(released) != 1 || released[0].Key != "shared.jpg" || released[0].RefCount != 0 {
+			t.Fatalf("released %+v, want shared.jpg", released)
+		}
+		if blob, _ := c.GetBlob("shared.jpg"); blob != nil {
+			t.Fatal("released blob still has a row")
+		}
+
+		released, err := c.DeleteVideo(first.ID)
+		if err != nil {
+			t.Fatalf("DeleteVideo: %v", err)
+		}
+		if len(released) != 1 || released[0].Key != "own.jpg" {
+			t.Fatalf("DeleteVideo released %+v, want own.jpg", released)
+		}
+
+		_, err = c.SetVideoBlobs(second.ID, BlobRoleThumbnail, []string{"missing.jpg"})
+		if !errors.Is(err, ErrBlobNotFound) {
+			t.Fatalf("unknown key: got %v, want ErrBlobNotFound", err)
+		}
+	})
+}
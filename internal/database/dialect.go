@@ -0,0 +1,110 @@
+package database
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialect is the SQL flavour of the database behind a Client. Queries are
+// written for SQLite, with ? placeholders, and rebound for Postgres.
+type dialect string
+
+const (
+	dialectSQLite   dialect = "sqlite"
+	dialectPostgres dialect = "postgres"
+)
+
+func (d dialect) driver() string {
+	if d == dialectSQLite {
+		return "sqlite3"
+	}
+	return string(d)
+}
+
+// dialectFor picks the dialect from a DSN: postgres:// URLs are Postgres,
+// anything else is a path to a SQLite file
+func dialectFor(dsn string) dialect {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return dialectPostgres
+	}
+	return dialectSQLite
+}
+
+// rebind rewrites ? placeholders to $1, $2, ... for Postgres, leaving any
+// inside quoted strings alone
+func (d dialect) rebind(query string) string {
+	if d != dialectPostgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	var quote rune
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// timeArg formats t for comparing with columns set by CURRENT_TIMESTAMP.
+// In SQLite those hold UTC text to the second, and compare as text.
+func (d dialect) timeArg(t time.Time) any {
+	if d == dialectSQLite {
+		return t.UTC().Format(time.DateTime)
+	}
+	return t
+}
+
+// dbConn is a *sql.DB that rebinds queries for its dialect
+type dbConn struct {
+	*sql.DB
+	dialect dialect
+}
+
+func (c dbConn) Exec(query string, args ...any) (sql.Result, error) {
+	return c.DB.Exec(c.dialect.rebind(query), args...)
+}
+
+func (c dbConn) Query(query string, args ...any) (*sql.Rows, error) {
+	return c.DB.Query(c.dialect.rebind(query), args...)
+}
+
+func (c dbConn) QueryRow(query string, args ...any) *sql.Row {
+	return c.DB.QueryRow(c.dialect.rebind(query), args...)
+}
+
+func (c dbConn) Begin() (dbTx, error) {
+	tx, err := c.DB.Begin()
+	return dbTx{tx, c.dialect}, err
+}
+
+// dbTx is a *sql.Tx that rebinds queries for its dialect
+type dbTx struct {
+	*sql.Tx
+	dialect dialect
+}
+
+func (t dbTx) Exec(query string, args ...any) (sql.Result, error) {
+	return t.Tx.Exec(t.dialect.rebind(query), args...)
+}
+
+func (t dbTx) Query(query string, args ...any) (*sql.Rows, error) {
+	return t.Tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t dbTx) QueryRow(query string, args ...any) *sql.Row {
+	return t.Tx.QueryRow(t.dialect.rebind(query), args...)
+}
@@ -0,0 +1,193 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type JobStatus string
+
+const (
+	JobQueued  JobStatus = "queued"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	// JobDead jobs ran out of attempts and wait for someone to look at them
+	JobDead JobStatus = "dead"
+)
+
+type Job struct {
+	ID             uuid.UUID  `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	Status         JobStatus  `json:"status"`
+	Attempts       int        `json:"attempts"`
+	RunAt          time.Time  `json:"run_at"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at"`
+	LastError      *string    `json:"last_error"`
+	EnqueueJobParams
+}
+
+type EnqueueJobParams struct {
+	Type        string `json:"type"`
+	Payload     []byte `json:"payload"`
+	MaxAttempts int    `json:"max_attempts"`
+}
+
+const jobColumns = `
+		id,
+		created_at,
+		updated_at,
+		type,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		run_at,
+		lease_expires_at,
+		last_error`
+
+func scanJob(row scanner) (Job, error) {
+	var job Job
+	err := row.Scan(
+		&job.ID,
+		&job.CreatedAt,
+		&job.UpdatedAt,
+		&job.Type,
+		&job.Payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LeaseExpiresAt,
+		&job.LastError,
+	)
+	return job, err
+}
+
+func (c Client) EnqueueJob(params EnqueueJobParams) (Job, error) {
+	id := uuid.New()
+	now := time.Now().UTC()
+	query := `
+	INSERT INTO jobs (
+		id,
+		created_at,
+		updated_at,
+		type,
+		payload,
+		status,
+		attempts,
+		max_attempts,
+		run_at
+	) VALUES (?, ?, ?, ?, ?, ?, 0, ?, ?)
+	`
+	_, err := c.db.Exec(query, id, now, now, params.Type, params.Payload, JobQueued, params.MaxAttempts, now)
+	if err != nil {
+		return Job{}, err
+	}
+	return c.GetJob(id)
+}
+
+func (c Client) GetJob(id uuid.UUID) (Job, error) {
+	query := `
+	SELECT` + jobColumns + `
+	FROM jobs
+	WHERE id = ?
+	`
+	job, err := scanJob(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Job{}, nil
+		}
+		return Job{}, err
+	}
+	return job, nil
+}
+
+// LeaseJob claims the next job that is due, or one whose previous lease ran
+// out because its worker died, and hides it from other workers until
+// leaseFor has passed. It returns nil when there is nothing to do.
+func (c Client) LeaseJob(leaseFor time.Duration) (*Job, error) {
+	now := time.Now().UTC()
+	// SQLite has a single writer anyway; on Postgres concurrent workers
+	// skip rows another worker is about to claim instead of queueing up
+	// behind it
+	lock := ""
+	if c.db.dialect == dialectPostgres {
+		lock = "FOR UPDATE SKIP LOCKED"
+	}
+	query := `
+	UPDATE jobs
+	SET
+		status = ?,
+		attempts = attempts + 1,
+		lease_expires_at = ?,
+		updated_at = ?
+	WHERE id = (
+		SELECT id FROM jobs
+		WHERE (status = ? AND run_at <= ?)
+			OR (status = ? AND lease_expires_at <= ?)
+		ORDER BY run_at
+		LIMIT 1
+		` + lock + `
+	)
+	RETURNING` + jobColumns
+
+	job, err := scanJob(c.db.QueryRow(query,
+		JobRunning, now.Add(leaseFor), now,
+		JobQueued, now,
+		JobRunning, now,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &job, nil
+}
+
+// ExtendJobLease keeps a long running job from being picked up again
+func (c Client) ExtendJobLease(id uuid.UUID, until time.Time) error {
+	query := `
+	UPDATE jobs
+	SET lease_expires_at = ?, updated_at = ?
+	WHERE id = ? AND status = ?
+	`
+	_, err := c.db.Exec(query, until.UTC(), time.Now().UTC(), id, JobRunning)
+	return err
+}
+
+func (c Client) CompleteJob(id uuid.UUID) error {
+	query := `
+	UPDATE jobs
+	SET status = ?, lease_expires_at = NULL, last_error = NULL, updated_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobDone, time.Now().UTC(), id)
+	return err
+}
+
+// RetryJob puts a failed job back in the queue to run again at runAt
+func (c Client) RetryJob(id uuid.UUID, runAt time.Time, lastError string) error {
+	query := `
+	UPDATE jobs
+	SET status = ?, run_at = ?, lease_expires_at = NULL, last_error = ?, updated_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobQueued, runAt.UTC(), lastError, time.Now().UTC(), id)
+	return err
+}
+
+// KillJob moves a job to the dead letter state, it won't be run again
+func (c Client) KillJob(id uuid.UUID, lastError string) error {
+	query := `
+	UPDATE jobs
+	SET status = ?, lease_expires_at = NULL, last_error = ?, updated_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, JobDead, lastError, time.Now().UTC(), id)
+	return err
+}
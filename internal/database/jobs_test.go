@@ -0,0 +1,62 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaseJob(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		queued, err := c.EnqueueJob(EnqueueJobParams{Type: "test", Payload: []byte(`{"n":1}`), MaxAttempts: 3})
+		if err != nil {
+			t.Fatalf("EnqueueJob: %v", err)
+		}
+
+		job, err := c.LeaseJob(time.Minute)
+		if err != nil {
+			t.Fatalf("LeaseJob: %v", err)
+		}
+		if job == nil || job.ID != queued.ID {
+			t.Fatalf("LeaseJob = %+v, want the queued job", job)
+		}
+		if job.Status != JobRunning || job.Attempts != 1 || job.LeaseExpiresAt == nil || string(job.Payload) != `{"n":1}` {
+			t.Fatalf("leased job = %+v", job)
+		}
+
+		job, err = c.LeaseJob(time.Minute)
+		if err != nil || job != nil {
+			t.Fatalf("second LeaseJob = %+v, %v, want nothing while leased", job, err)
+		}
+
+		err = c.CompleteJob(queued.ID)
+		if err != nil {
+			t.Fatalf("CompleteJob: %v", err)
+		}
+		job, err = c.LeaseJob(time.Minute)
+		if err != nil || job != nil {
+			t.Fatalf("LeaseJob after completing = %+v, %v", job, err)
+		}
+	})
+}
+
+func TestLeaseJobAfterLeaseExpires(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		queued, err := c.EnqueueJob(EnqueueJobParams{Type: "test", MaxAttempts: 3})
+		if err != nil {
+			t.Fatalf("EnqueueJob: %v", err)
+		}
+		// a worker that died holding the job
+		_, err = c.LeaseJob(-time.Second)
+		if err != nil {
+			t.Fatalf("LeaseJob: %v", err)
+		}
+
+		job, err := c.LeaseJob(time.Minute)
+		if err != nil {
+			t.Fatalf("LeaseJob: %v", err)
+		}
+		if job == nil || job.ID != queued.ID || job.Attempts != 2 {
+			t.Fatalf("LeaseJob = %+v, want the abandoned job on its second attempt", job)
+		}
+	})
+}
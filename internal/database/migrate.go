@@ -0,0 +1,479 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations live in migrations/<dialect>/ as NNNN_name.up.sql and
+// NNNN_name.down.sql, with the same versions for every dialect. Never edit
+// one that has shipped; add a new one instead. Applied migrations are
+// checked against their checksum on every start.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+// migrationLockID is the Postgres advisory lock held while migrating, so
+// servers starting at the same time don't both apply a migration
+const migrationLockID = 7_312_019
+
+// baselineVersion is the migration matching the schema autoMigrate used to
+// create, which databases from before migrations are recorded at
+const baselineVersion = 1
+
+// searchIndexTable is the FTS5 table SQLite searches use. Migrations that
+// create FTS5 tables are recorded without running on builds without FTS5,
+// and run once a build with it opens the database.
+const searchIndexTable = "videos_fts"
+
+func needsFTS5(script string) bool {
+	return strings.Contains(script, "USING fts5")
+}
+
+type migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus is a known migration and whether it is applied.
+// Modified means the file changed after it was applied.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+	Modified  bool
+}
+
+func loadMigrations(d dialect) ([]migration, error) {
+	paths, err := fs.Glob(migrationFiles, path.Join("migrations", string(d), "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*migration{}
+	for _, p := range paths {
+		file := path.Base(p)
+		base, direction, ok := strings.Cut(strings.TrimSuffix(file, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("migration %s: expected NNNN_name.up.sql or NNNN_name.down.sql", file)
+		}
+		number, name, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: invalid version %q", file, number)
+		}
+		data, err := migrationFiles.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(data)
+			sum := sha256.Sum256(data)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both an up and a down script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+func (c Client) appliedMigrations() (map[int]appliedMigration, error) {
+	_, err := c.db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := c.db.Query("SELECT version, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]appliedMigration{}
+	for rows.Next() {
+		var version int
+		var a appliedMigration
+		if err := rows.Scan(&version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = a
+	}
+	return applied, rows.Err()
+}
+
+// checkMigrations makes sure every applied migration is one this build
+// knows, unchanged
+func checkMigrations(migrations []migration, applied map[int]appliedMigration) error {
+	known := map[int]migration{}
+	for _, m := range migrations {
+		known[m.Version] = m
+	}
+	for version, a := range applied {
+		m, ok := known[version]
+		if !ok {
+			return fmt.Errorf("database has migration %d applied, which this build doesn't know; was it migrated by a newer version?", version)
+		}
+		if m.Checksum != a.checksum {
+			return fmt.Errorf("migration %04d_%s was changed after it was applied", m.Version, m.Name)
+		}
+	}
+	return nil
+}
+
+// MigrateUp applies every pending migration in order and returns how many
+// it applied
+func (c Client) MigrateUp() (int, error) {
+	migrations, err := loadMigrations(c.db.dialect)
+	if err != nil {
+		return 0, err
+	}
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+	// the triggers keeping the index in sync fail on every change to videos
+	// without FTS5
+	if c.db.dialect == dialectSQLite && !c.fts5 {
+		indexed, err := c.tableExists(searchIndexTable)
+		if err != nil {
+			return 0, err
+		}
+		if indexed {
+			return 0, errors.New("the database has a full-text search index, which needs a build with -tags sqlite_fts5")
+		}
+	}
+	// only SQLite databases predate migrations
+	if len(applied) == 0 && c.db.dialect == dialectSQLite {
+		legacy, err := c.tableExists("videos")
+		if err != nil {
+			return 0, err
+		}
+		if legacy {
+			err = c.baselineLegacy(migrations[0])
+			if err != nil {
+				return 0, fmt.Errorf("couldn't upgrade database from before migrations: %w", err)
+			}
+			applied, err = c.appliedMigrations()
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	err = checkMigrations(migrations, applied)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		ran, err := c.runMigration(m, true)
+		if err != nil {
+			return count, err
+		}
+		if ran {
+			count++
+		}
+	}
+
+	if c.db.dialect == dialectSQLite && c.fts5 {
+		err = c.buildSkippedSearchIndex(migrations)
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// buildSkippedSearchIndex runs the FTS5 migrations a build without FTS5
+// recorded but skipped
+func (c Client) buildSkippedSearchIndex(migrations []migration) error {
+	indexed, err := c.tableExists(searchIndexTable)
+	if err != nil || indexed {
+		return err
+	}
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok || !needsFTS5(m.Up) {
+			continue
+		}
+		err = c.execScript(m.Up)
+		if err != nil {
+			return fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// execScript runs the statements in script in one transaction
+func (c Client) execScript(script string) error {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(script)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// MigrateDown reverts the last steps applied migrations
+func (c Client) MigrateDown(steps int) error {
+	migrations, err := loadMigrations(c.db.dialect)
+	if err != nil {
+		return err
+	}
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return err
+	}
+	err = checkMigrations(migrations, applied)
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		_, err := c.runMigration(m, false)
+		if err != nil {
+			return err
+		}
+		steps--
+	}
+	return nil
+}
+
+// MigrationStatus lists every migration this build knows
+func (c Client) MigrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations(c.db.dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := c.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationStatus{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			s.AppliedAt = &a.appliedAt
+			s.Modified = a.checksum != m.Checksum
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// runMigration applies m, or reverts it if up is false, and records that
+// in schema_migrations in the same transaction. It reports false if
+// another server got there first.
+func (c Client) runMigration(m migration, up bool) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if tx.dialect == dialectPostgres {
+		_, err = tx.Exec("SELECT pg_advisory_xact_lock(?)", migrationLockID)
+		if err != nil {
+			return false, err
+		}
+	}
+	var applied bool
+	err = tx.QueryRow("SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = ?)", m.Version).Scan(&applied)
+	if err != nil {
+		return false, err
+	}
+	if applied == up {
+		return false, nil
+	}
+
+	script := m.Up
+	if !up {
+		script = m.Down
+	}
+	// without FTS5 search falls back to a table scan, see SearchVideos
+	skip := up && tx.dialect == dialectSQLite && !c.fts5 && needsFTS5(script)
+	if !skip {
+		_, err = tx.Exec(script)
+	}
+	if err != nil {
+		return false, fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+	}
+	if up {
+		_, err = tx.Exec("INSERT INTO schema_migrations (version, name, checksum) VALUES (?, ?, ?)",
+			m.Version, m.Name, m.Checksum)
+	} else {
+		_, err = tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (c Client) tableExists(name string) (bool, error) {
+	var exists bool
+	err := c.db.QueryRow("SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)", name).Scan(&exists)
+	return exists, err
+}
+
+// baselineLegacy records a database autoMigrate created at the baseline
+// version. Depending on how old it is some tables or columns may be
+// missing, so those are added first.
+func (c Client) baselineLegacy(baseline migration) error {
+	if baseline.Version != baselineVersion {
+		return errors.New("baseline migration is missing")
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// the baseline only uses CREATE ... IF NOT EXISTS, so this adds
+	// whichever tables the database doesn't have yet
+	_, err = tx.Exec(baseline.Up)
+	if err != nil {
+		return err
+	}
+
+	videoColumns := []struct{ name, definition string }{
+		{"thumbnails", "TEXT"},
+		{"width", "INTEGER"},
+		{"height", "INTEGER"},
+		{"duration", "REAL"},
+		{"codec", "TEXT"},
+		{"orientation", "TEXT"},
+		{"hls_manifest_url", "TEXT"},
+		{"status", "TEXT NOT NULL DEFAULT 'draft'"},
+		{"failure_reason", "TEXT"},
+		{"custom_thumbnail", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"thumbnail_candidates", "TEXT"},
+		{"preview_vtt_url", "TEXT"},
+		{"captions", "TEXT"},
+	}
+	for _, col := range videoColumns {
+		added, err := ensureColumn(tx, "videos", col.name, col.definition)
+		if err != nil {
+			return err
+		}
+		if !added {
+			continue
+		}
+		switch col.name {
+		case "status":
+			// videos from before statuses existed are ready if they have a file
+			_, err = tx.Exec("UPDATE videos SET status = 'ready' WHERE video_url IS NOT NULL")
+		case "custom_thumbnail":
+			// every thumbnail from before extraction existed was uploaded
+			_, err = tx.Exec("UPDATE videos SET custom_thumbnail = 1 WHERE thumbnail_url IS NOT NULL")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("INSERT INTO schema_migrations (version, name, checksum) VALUES (?, ?, ?)",
+		baseline.Version, baseline.Name, baseline.Checksum)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ensureColumn adds a column to table unless it already exists, and
+// reports whether it did
+func ensureColumn(tx dbTx, table, column, definition string) (bool, error) {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   bool
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return false, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return false, fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return true, nil
+}
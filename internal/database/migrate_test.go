@@ -0,0 +1,97 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestMigrateDownAndUp(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		statuses, err := c.MigrationStatus()
+		if err != nil {
+			t.Fatalf("MigrationStatus: %v", err)
+		}
+		for _, s := range statuses {
+			if s.AppliedAt == nil || s.Modified {
+				t.Fatalf("migration %04d_%s not applied cleanly: %+v", s.Version, s.Name, s)
+			}
+		}
+
+		err = c.MigrateDown(len(statuses))
+		if err != nil {
+			t.Fatalf("MigrateDown: %v", err)
+		}
+		statuses, err = c.MigrationStatus()
+		if err != nil {
+			t.Fatalf("MigrationStatus: %v", err)
+		}
+		for _, s := range statuses {
+			if s.AppliedAt != nil {
+				t.Fatalf("migration %04d_%s still applied", s.Version, s.Name)
+			}
+		}
+
+		n, err := c.MigrateUp()
+		if err != nil {
+			t.Fatalf("MigrateUp: %v", err)
+		}
+		if n != len(statuses) {
+			t.Fatalf("MigrateUp applied %d migrations, want %d", n, len(statuses))
+		}
+		n, err = c.MigrateUp()
+		if err != nil || n != 0 {
+			t.Fatalf("second MigrateUp = %d, %v, want nothing to do", n, err)
+		}
+
+		// the schema works after a round trip
+		createVideo(t, c, createUser(t, c, "a@example.com"), "title", "description")
+	})
+}
+
+func TestCheckMigrations(t *testing.T) {
+	migrations := []migration{{Version: 1, Name: "initial", Checksum: "abc"}}
+
+	err := checkMigrations(migrations, map[int]appliedMigration{1: {checksum: "abc"}})
+	if err != nil {
+		t.Fatalf("unchanged migration: %v", err)
+	}
+	err = checkMigrations(migrations, map[int]appliedMigration{1: {checksum: "def"}})
+	if err == nil {
+		t.Fatal("expected an error for a migration changed after it was applied")
+	}
+	err = checkMigrations(migrations, map[int]appliedMigration{1: {checksum: "abc"}, 2: {checksum: "abc"}})
+	if err == nil {
+		t.Fatal("expected an error for a migration this build doesn't know")
+	}
+}
+
+func TestLoadMigrationsMatchAcrossDialects(t *testing.T) {
+	sqlite, err := loadMigrations(dialectSQLite)
+	if err != nil {
+		t.Fatalf("sqlite: %v", err)
+	}
+	postgres, err := loadMigrations(dialectPostgres)
+	if err != nil {
+		t.Fatalf("postgres: %v", err)
+	}
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("%d SQLite migrations but %d Postgres ones", len(sqlite), len(postgres))
+	}
+	for i := range sqlite {
+		if sqlite[i].Version != postgres[i].Version || sqlite[i].Name != postgres[i].Name {
+			t.Errorf("migration %d is %04d_%s on SQLite but %04d_%s on Postgres", i,
+				sqlite[i].Version, sqlite[i].Name, postgres[i].Version, postgres[i].Name)
+		}
+	}
+}
+
+func TestSearchIndexFollowsFTS5(t *testing.T) {
+	c := newSQLiteClient(t)
+	indexed, err := c.tableExists(searchIndexTable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// without FTS5 the migration is recorded but skipped
+	if indexed != c.fts5 {
+		t.Fatalf("%s exists = %v, but FTS5 available = %v", searchIndexTable, indexed, c.fts5)
+	}
+}
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS jobs;
+DROP TABLE IF EXISTS video_blobs;
+DROP TABLE IF EXISTS blobs;
+DROP TABLE IF EXISTS uploads;
+DROP TABLE IF EXISTS videos;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS users;
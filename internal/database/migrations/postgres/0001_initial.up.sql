@@ -0,0 +1,81 @@
+CREATE TABLE users (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	password TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL
+);
+
+CREATE TABLE refresh_tokens (
+	token TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMPTZ,
+	user_id TEXT NOT NULL REFERENCES users(id),
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE videos (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	thumbnails TEXT,
+	custom_thumbnail BOOLEAN NOT NULL DEFAULT FALSE,
+	thumbnail_candidates TEXT,
+	video_url TEXT,
+	width INTEGER,
+	height INTEGER,
+	duration DOUBLE PRECISION,
+	codec TEXT,
+	orientation TEXT,
+	hls_manifest_url TEXT,
+	preview_vtt_url TEXT,
+	captions TEXT,
+	status TEXT NOT NULL DEFAULT 'draft',
+	failure_reason TEXT,
+	user_id TEXT REFERENCES users(id)
+);
+
+CREATE TABLE uploads (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	video_id TEXT NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+	user_id TEXT NOT NULL REFERENCES users(id),
+	length BIGINT NOT NULL,
+	upload_offset BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE blobs (
+	key TEXT PRIMARY KEY,
+	hash TEXT UNIQUE,
+	size BIGINT NOT NULL,
+	content_type TEXT NOT NULL,
+	ref_count INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE video_blobs (
+	video_id TEXT NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+	blob_key TEXT NOT NULL REFERENCES blobs(key),
+	role TEXT NOT NULL,
+	PRIMARY KEY (video_id, blob_key, role)
+);
+
+CREATE TABLE jobs (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	type TEXT NOT NULL,
+	payload BYTEA,
+	status TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	max_attempts INTEGER NOT NULL,
+	run_at TIMESTAMPTZ NOT NULL,
+	lease_expires_at TIMESTAMPTZ,
+	last_error TEXT
+);
+CREATE INDEX jobs_status_run_at ON jobs(status, run_at);
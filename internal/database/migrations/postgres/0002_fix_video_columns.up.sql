@@ -0,0 +1,3 @@
+-- the Postgres schema started out with these columns right, only the
+-- index is missing
+CREATE INDEX videos_user_id ON videos(user_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS videos_search;
+ALTER TABLE videos DROP COLUMN IF EXISTS search;
@@ -0,0 +1,8 @@
+-- titles weigh more than descriptions when ranking. The 'simple' config
+-- doesn't stem, as titles are in any language.
+ALTER TABLE videos ADD COLUMN search tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', COALESCE(title, '')), 'A') ||
+	setweight(to_tsvector('simple', COALESCE(description, '')), 'B')
+) STORED;
+
+CREATE INDEX videos_search ON videos USING GIN (search);
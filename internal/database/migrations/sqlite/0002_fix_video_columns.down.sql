@@ -0,0 +1,74 @@
+CREATE TABLE videos_new (
+	id TEXT PRIMARY KEY,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	title TEXT NOT NULL,
+	description TEXT,
+	thumbnail_url TEXT,
+	thumbnails TEXT,
+	custom_thumbnail BOOLEAN NOT NULL DEFAULT 0,
+	thumbnail_candidates TEXT,
+	video_url TEXT TEXT,
+	width INTEGER,
+	height INTEGER,
+	duration REAL,
+	codec TEXT,
+	orientation TEXT,
+	hls_manifest_url TEXT,
+	preview_vtt_url TEXT,
+	captions TEXT,
+	status TEXT NOT NULL DEFAULT 'draft',
+	failure_reason TEXT,
+	user_id INTEGER,
+	FOREIGN KEY(user_id) REFERENCES users(id)
+);
+
+INSERT INTO videos_new (
+	id,
+	created_at,
+	updated_at,
+	title,
+	description,
+	thumbnail_url,
+	thumbnails,
+	custom_thumbnail,
+	thumbnail_candidates,
+	video_url,
+	width,
+	height,
+	duration,
+	codec,
+	orientation,
+	hls_manifest_url,
+	preview_vtt_url,
+	captions,
+	status,
+	failure_reason,
+	user_id
+)
+SELECT
+	id,
+	created_at,
+	updated_at,
+	title,
+	description,
+	thumbnail_url,
+	thumbnails,
+	custom_thumbnail,
+	thumbnail_candidates,
+	video_url,
+	width,
+	height,
+	duration,
+	codec,
+	orientation,
+	hls_manifest_url,
+	preview_vtt_url,
+	captions,
+	status,
+	failure_reason,
+	user_id
+FROM videos;
+
+DROP TABLE videos;
+ALTER TABLE videos_new RENAME TO videos;
@@ -0,0 +1,2 @@
+-- the default listing of a user's videos, newest first
+CREATE INDEX videos_user_id_created_at ON videos(user_id, created_at, id);
@@ -0,0 +1,2 @@
+-- public videos show up in everyone's search results
+ALTER TABLE videos ADD COLUMN is_public BOOLEAN NOT NULL DEFAULT 0;
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Upload is a resumable upload in progress. Offset is the number of bytes
+// received and safely written so far.
+type Upload struct {
+	ID        uuid.UUID `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Offset    int64     `json:"offset"`
+	CreateUploadParams
+}
+
+type CreateUploadParams struct {
+	VideoID uuid.UUID `json:"video_id"`
+	UserID  uuid.UUID `json:"user_id"`
+	Length  int64     `json:"length"`
+}
+
+func (c Client) CreateUpload(params CreateUploadParams) (*Upload, error) {
+	id := uuid.New()
+	query := `
+	INSERT INTO uploads (
+		id,
+		created_at,
+		updated_at,
+		video_id,
+		user_id,
+		length,
+		upload_offset
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, 0)
+	`
+	_, err := c.db.Exec(query, id, params.VideoID, params.UserID, params.Length)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetUpload(id)
+}
+
+const uploadColumns = `
+	id, created_at, updated_at, video_id, user_id, length, upload_offset
+`
+
+func scanUpload(s scanner) (Upload, error) {
+	var upload Upload
+	err := s.Scan(
+		&upload.ID,
+		&upload.CreatedAt,
+		&upload.UpdatedAt,
+		&upload.VideoID,
+		&upload.UserID,
+		&upload.Length,
+		&upload.Offset,
+	)
+	return upload, err
+}
+
+func (c Client) GetUpload(id uuid.UUID) (*Upload, error) {
+	query := `
+	SELECT` + uploadColumns + `
+	FROM uploads
+	WHERE id = ?
+	`
+	upload, err := scanUpload(c.db.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &upload, nil
+}
+
+// GetUploads returns every upload in progress
+func (c Client) GetUploads() ([]Upload, error) {
+	query := `
+	SELECT` + uploadColumns + `
+	FROM uploads
+	`
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uploads := []Upload{}
+	for rows.Next() {
+		upload, err := scanUpload(rows)
+		if err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, rows.Err()
+}
+
+// UpdateUploadOffset moves an upload's offset from one value to another.
+// It reports false if the offset was no longer from, or the upload is
+// gone, so two writers can't both think they appended at the same place.
+func (c Client) UpdateUploadOffset(id uuid.UUID, from, to int64) (bool, error) {
+	query := `
+	UPDATE uploads
+	SET upload_offset = ?, updated_at = CURRENT_TIMESTAMP
+	WHERE id = ? AND upload_offset = ?
+	`
+	result, err := c.db.Exec(query, to, id, from)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (c Client) DeleteUpload(id uuid.UUID) error {
+	query := `
+	DELETE FROM uploads
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, id)
+	return err
+}
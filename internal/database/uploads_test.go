@@ -0,0 +1,34 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestUpdateUploadOffset(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		userID := createUser(t, c, "a@example.com")
+		video := createVideo(t, c, userID, "title", "")
+		upload, err := c.CreateUpload(CreateUploadParams{VideoID: video.ID, UserID: userID, Length: 100})
+		if err != nil {
+			t.Fatalf("CreateUpload: %v", err)
+		}
+
+		ok, err := c.UpdateUploadOffset(upload.ID, 0, 40)
+		if err != nil || !ok {
+			t.Fatalf("UpdateUploadOffset(0, 40) = %v, %v", ok, err)
+		}
+		// a second writer that also started at 0
+		ok, err = c.UpdateUploadOffset(upload.ID, 0, 60)
+		if err != nil || ok {
+			t.Fatalf("UpdateUploadOffset(0, 60) = %v, %v, want it refused", ok, err)
+		}
+
+		got, err := c.GetUpload(upload.ID)
+		if err != nil {
+			t.Fatalf("GetUpload: %v", err)
+		}
+		if got.Offset != 40 {
+			t.Fatalf("offset = %d, want 40", got.Offset)
+		}
+	})
+}
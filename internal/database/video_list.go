@@ -0,0 +1,229 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultVideoPageSize = 50
+	MaxVideoPageSize     = 100
+)
+
+var ErrInvalidVideoQuery = errors.New("invalid video query")
+
+// videoSort is a column videos can be listed by. key is selected along
+// with each row to build the cursor from; timestamps go through TEXT so
+// the cursor holds exactly what the database compares against. numeric
+// keys come back from a cursor as JSON numbers, the rest as strings.
+type videoSort struct {
+	expr        string
+	key         string
+	numeric     bool
+	defaultDesc bool
+}
+
+var videoSorts = map[string]videoSort{
+	"created_at": {"created_at", "CAST(created_at AS TEXT)", false, true},
+	"updated_at": {"updated_at", "CAST(updated_at AS TEXT)", false, true},
+	"title":      {"title", "title", false, false},
+	// drafts have no duration yet and sort as zero length
+	"duration": {"COALESCE(duration, 0)", "COALESCE(duration, 0)", true, true},
+}
+
+// ListVideosParams selects a page of a user's videos. Sort defaults to
+// created_at; Order is "asc" or "desc" and defaults to newest, longest or
+// A-Z first. Cursor is a previous page's NextCursor.
+type ListVideosParams struct {
+	UserID        uuid.UUID
+	Limit         int
+	Sort          string
+	Order         string
+	Cursor        string
+	HasVideo      *bool
+	Statuses      []VideoStatus
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+}
+
+// VideoPage is one page of videos. NextCursor is empty on the last page.
+type VideoPage struct {
+	Videos     []Video
+	NextCursor string
+}
+
+// videoCursor points just past the last video of a page. It records the
+// sort it was made for, as it means nothing under another one.
+type videoCursor struct {
+	Sort  string    `json:"s"`
+	Order string    `json:"o"`
+	Key   any       `json:"k"`
+	ID    uuid.UUID `json:"id"`
+}
+
+func (c videoCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeVideoCursor(s string) (videoCursor, error) {
+	var cursor videoCursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor, err
+	}
+	err = json.Unmarshal(data, &cursor)
+	if err != nil {
+		return cursor, err
+	}
+	// the key is compared with the sort column, which a key of another
+	// type would make an error in Postgres and meaningless in SQLite
+	sort, ok := videoSorts[cursor.Sort]
+	if !ok {
+		return cursor, fmt.Errorf("unknown sort %q", cursor.Sort)
+	}
+	switch cursor.Key.(type) {
+	case string:
+		if !sort.numeric {
+			return cursor, nil
+		}
+	case float64:
+		if sort.numeric {
+			return cursor, nil
+		}
+	}
+	return cursor, fmt.Errorf("unexpected key %v for sort %s", cursor.Key, cursor.Sort)
+}
+
+// ListVideos returns a page of the user's videos, using keyset pagination
+// on the sort column and id so pages stay cheap however deep they go
+func (c Client) ListVideos(params ListVideosParams) (VideoPage, error) {
+	if params.Sort == "" {
+		params.Sort = "created_at"
+	}
+	sort, ok := videoSorts[params.Sort]
+	if !ok {
+		return VideoPage{}, fmt.Errorf("%w: can't sort by %q", ErrInvalidVideoQuery, params.Sort)
+	}
+	switch params.Order {
+	case "":
+		params.Order = "asc"
+		if sort.defaultDesc {
+			params.Order = "desc"
+		}
+	case "asc", "desc":
+	default:
+		return VideoPage{}, fmt.Errorf("%w: order must be asc or desc", ErrInvalidVideoQuery)
+	}
+	if params.Limit <= 0 {
+		params.Limit = DefaultVideoPageSize
+	}
+	params.Limit = min(params.Limit, MaxVideoPageSize)
+
+	where := []string{"user_id = ?"}
+	args := []any{params.UserID}
+	if params.HasVideo != nil {
+		if *params.HasVideo {
+			where = append(where, "video_url IS NOT NULL")
+		} else {
+			where = append(where, "video_url IS NULL")
+		}
+	}
+	if len(params.Statuses) > 0 {
+		where = append(where, "status IN (?"+strings.Repeat(", ?", len(params.Statuses)-1)+")")
+		for _, status := range params.Statuses {
+			switch status {
+			case VideoDraft, VideoUploading, VideoProcessing, VideoReady, VideoFailed:
+			default:
+				return VideoPage{}, fmt.Errorf("%w: unknown status %q", ErrInvalidVideoQuery, status)
+			}
+			args = append(args, status)
+		}
+	}
+	if params.CreatedAfter != nil {
+		where = append(where, "created_at >= ?")
+		args = append(args, c.db.dialect.timeArg(*params.CreatedAfter))
+	}
+	if params.CreatedBefore != nil {
+		where = append(where, "created_at < ?")
+		args = append(args, c.db.dialect.timeArg(*params.CreatedBefore))
+	}
+
+	direction, compare := "ASC", ">"
+	if params.Order == "desc" {
+		direction, compare = "DESC", "<"
+	}
+	if params.Cursor != "" {
+		cursor, err := decodeVideoCursor(params.Cursor)
+		if err != nil {
+			return VideoPage{}, fmt.Errorf("%w: malformed cursor", ErrInvalidVideoQuery)
+		}
+		if cursor.Sort != params.Sort || cursor.Order != params.Order {
+			return VideoPage{}, fmt.Errorf("%w: cursor is for a different sort", ErrInvalidVideoQuery)
+		}
+		where = append(where, fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))",
+			sort.expr, compare, sort.expr, compare))
+		args = append(args, cursor.Key, cursor.Key, cursor.ID)
+	}
+
+	// one extra row tells whether there is another page
+	query := `
+	SELECT` + videoColumns + `,
+		` + sort.key + `
+	FROM videos
+	WHERE ` + strings.Join(where, " AND ") + `
+	ORDER BY ` + sort.expr + ` ` + direction + `, id ` + direction + `
+	LIMIT ?
+	`
+	args = append(args, params.Limit+1)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		return VideoPage{}, err
+	}
+	defer rows.Close()
+
+	page := VideoPage{Videos: []Video{}}
+	var lastKey any
+	for rows.Next() {
+		if len(page.Videos) == params.Limit {
+			last := page.Videos[len(page.Videos)-1]
+			page.NextCursor = videoCursor{
+				Sort:  params.Sort,
+				Order: params.Order,
+				Key:   lastKey,
+				ID:    last.ID,
+			}.encode()
+			break
+		}
+		var key any
+		video, err := scanVideo(extraScanner{rows, []any{&key}})
+		if err != nil {
+			return VideoPage{}, err
+		}
+		if b, ok := key.([]byte); ok {
+			key = string(b)
+		}
+		lastKey = key
+		page.Videos = append(page.Videos, video)
+	}
+	return page, rows.Err()
+}
+
+// extraScanner scans a video row followed by more columns, such as a sort
+// key
+type extraScanner struct {
+	rows  *sql.Rows
+	extra []any
+}
+
+func (s extraScanner) Scan(dest ...any) error {
+	return s.rows.Scan(append(dest, s.extra...)...)
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestListVideosPages(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		userID := createUser(t, c, "a@example.com")
+		want := []string{"alpha", "bravo", "charlie", "delta", "echo"}
+		for _, title := range []string{"delta", "alpha", "echo", "charlie", "bravo"} {
+			createVideo(t, c, userID, title, "")
+		}
+		createVideo(t, c, createUser(t, c, "b@example.com"), "another user's", "")
+
+		var titles []string
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > len(want) {
+				t.Fatal("paging doesn't end")
+			}
+			page, err := c.ListVideos(ListVideosParams{UserID: userID, Limit: 2, Sort: "title", Cursor: cursor})
+			if err != nil {
+				t.Fatalf("ListVideos: %v", err)
+			}
+			for _, video := range page.Videos {
+				titles = append(titles, video.Title)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+		if !slices.Equal(titles, want) {
+			t.Fatalf("paged through %v, want %v", titles, want)
+		}
+
+		page, err := c.ListVideos(ListVideosParams{UserID: userID, Limit: 10, Sort: "title", Order: "desc"})
+		if err != nil {
+			t.Fatalf("ListVideos: %v", err)
+		}
+		if len(page.Videos) != 5 || page.Videos[0].Title != "echo" || page.NextCursor != "" {
+			t.Fatalf("descending page = %d videos starting %q, cursor %q", len(page.Videos), page.Videos[0].Title, page.NextCursor)
+		}
+	})
+}
+
+func TestListVideosRejectsCursors(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		userID := createUser(t, c, "a@example.com")
+		for _, title := range []string{"alpha", "bravo", "charlie"} {
+			createVideo(t, c, userID, title, "")
+		}
+
+		// a real cursor of each sort works
+		for sort := range videoSorts {
+			page, err := c.ListVideos(ListVideosParams{UserID: userID, Limit: 1, Sort: sort})
+			if err != nil {
+				t.Fatalf("ListVideos by %s: %v", sort, err)
+			}
+			_, err = c.ListVideos(ListVideosParams{UserID: userID, Limit: 1, Sort: sort, Cursor: page.NextCursor})
+			if err != nil {
+				t.Fatalf("second page by %s: %v", sort, err)
+			}
+		}
+
+		id := uuid.New()
+		tests := []struct {
+			name   string
+			sort   string
+			cursor string
+		}{
+			{"not base64", "title", "!!!"},
+			{"not JSON", "title", base64.RawURLEncoding.EncodeToString([]byte("title"))},
+			{"number for a title", "title", videoCursor{Sort: "title", Order: "asc", Key: 1.5, ID: id}.encode()},
+			{"number for a timestamp", "created_at", videoCursor{Sort: "created_at", Order: "desc", Key: 1e9, ID: id}.encode()},
+			{"string for a duration", "duration", videoCursor{Sort: "duration", Order: "desc", Key: "long", ID: id}.encode()},
+			{"boolean", "title", videoCursor{Sort: "title", Order: "asc", Key: true, ID: id}.encode()},
+			{"object", "title", videoCursor{Sort: "title", Order: "asc", Key: map[string]any{}, ID: id}.encode()},
+			{"no key", "title", videoCursor{Sort: "title", Order: "asc", ID: id}.encode()},
+			{"unknown sort", "title", videoCursor{Sort: "views", Order: "asc", Key: "x", ID: id}.encode()},
+			{"another sort", "title", videoCursor{Sort: "duration", Order: "asc", Key: 1.5, ID: id}.encode()},
+			{"another order", "title", videoCursor{Sort: "title", Order: "desc", Key: "x", ID: id}.encode()},
+		}
+		for _, tt := range tests {
+			_, err := c.ListVideos(ListVideosParams{UserID: userID, Sort: tt.sort, Cursor: tt.cursor})
+			if !errors.Is(err, ErrInvalidVideoQuery) {
+				t.Errorf("%s: got %v, want ErrInvalidVideoQuery", tt.name, err)
+			}
+		}
+	})
+}
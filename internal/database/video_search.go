@@ -0,0 +1,243 @@
+package database
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// highlightStart and highlightEnd wrap matches in what the database
+// returns, to be swapped for <mark> tags once the text is escaped
+const (
+	highlightStart = "\x02"
+	highlightEnd   = "\x03"
+)
+
+// VideoSearchResult is a video matching a search. TitleHighlight and
+// DescriptionSnippet are HTML, escaped, with the matches wrapped in <mark>.
+// Higher ranks are better matches.
+type VideoSearchResult struct {
+	Video              Video   `json:"video"`
+	Rank               float64 `json:"rank"`
+	TitleHighlight     string  `json:"title_highlight"`
+	DescriptionSnippet string  `json:"description_snippet"`
+}
+
+// searchTerms splits a search into words, dropping anything that would be
+// syntax to the full-text engine
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// SearchVideos finds the user's videos and public ones whose title or
+// description contain every word of query, the last letters of each word
+// being optional so partial words match too. Best matches come first.
+func (c Client) SearchVideos(userID uuid.UUID, query string, limit int) ([]VideoSearchResult, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return []VideoSearchResult{}, nil
+	}
+	if limit <= 0 {
+		limit = DefaultSearchLimit
+	}
+	limit = min(limit, MaxSearchLimit)
+	if !c.FullTextSearch() {
+		return c.searchVideosLike(userID, terms, limit)
+	}
+
+	var sqlQuery string
+	var args []any
+	if c.db.dialect == dialectPostgres {
+		for i, term := range terms {
+			terms[i] = term + ":*"
+		}
+		options := "StartSel=" + highlightStart + ", StopSel=" + highlightEnd
+		sqlQuery = `
+		SELECT` + videoColumns + `,
+			ts_rank(search, q) AS rank,
+			ts_headline('simple', title, q, ?),
+			ts_headline('simple', COALESCE(description, ''), q, ?)
+		FROM videos, to_tsquery('simple', ?) AS q
+		WHERE (user_id = ? OR is_public) AND search @@ q
+		ORDER BY rank DESC, id
+		LIMIT ?
+		`
+		args = []any{
+			options + ", HighlightAll=true",
+			options + ", MaxWords=20, MinWords=8",
+			strings.Join(terms, " & "),
+			userID,
+			limit,
+		}
+	} else {
+		for i, term := range terms {
+			terms[i] = `"` + term + `"*`
+		}
+		// bm25 is lower for better matches, and weighs title matches ten
+		// times as much as description ones. The hits are filtered and
+		// limited before the rest of the columns are fetched.
+		sqlQuery = `
+		SELECT` + videoColumns + `,
+			hits.rank,
+			hits.title_highlight,
+			hits.description_snippet
+		FROM videos
+		JOIN (
+			SELECT
+				video_id,
+				-bm25(videos_fts, 0, 10.0, 1.0) AS rank,
+				highlight(videos_fts, 1, ?, ?) AS title_highlight,
+				snippet(videos_fts, 2, ?, ?, '…', 16) AS description_snippet
+			FROM videos_fts
+			JOIN videos AS v ON v.id = videos_fts.video_id
+			WHERE videos_fts MATCH ? AND (v.user_id = ? OR v.is_public)
+			ORDER BY rank DESC, video_id
+			LIMIT ?
+		) AS hits ON hits.video_id = videos.id
+		ORDER BY hits.rank DESC, id
+		`
+		args = []any{
+			highlightStart, highlightEnd,
+			highlightStart, highlightEnd,
+			strings.Join(terms, " "),
+			userID,
+			limit,
+		}
+	}
+
+	rows, err := c.db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []VideoSearchResult{}
+	for rows.Next() {
+		var result VideoSearchResult
+		result.Video, err = scanVideo(extraScanner{rows, []any{
+			&result.Rank,
+			&result.TitleHighlight,
+			&result.DescriptionSnippet,
+		}})
+		if err != nil {
+			return nil, err
+		}
+		result.TitleHighlight = markHighlights(result.TitleHighlight)
+		result.DescriptionSnippet = markHighlights(result.DescriptionSnippet)
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// searchVideosLike is SearchVideos for SQLite without FTS5. It scans every
+// video the user can see for the words anywhere in the title or description,
+// ranking title matches ten times as high, and highlights matches itself.
+func (c Client) searchVideosLike(userID uuid.UUID, terms []string, limit int) ([]VideoSearchResult, error) {
+	// terms are only letters and digits, so none of them are LIKE syntax
+	var rank, match []string
+	var rankArgs, matchArgs []any
+	for _, term := range terms {
+		pattern := "%" + term + "%"
+		rank = append(rank, "(title LIKE ?) * 10 + (COALESCE(description, '') LIKE ?)")
+		rankArgs = append(rankArgs, pattern, pattern)
+		match = append(match, "(title LIKE ? OR description LIKE ?)")
+		matchArgs = append(matchArgs, pattern, pattern)
+	}
+	sqlQuery := `
+	SELECT` + videoColumns + `,
+		` + strings.Join(rank, " + ") + ` AS rank
+	FROM videos
+	WHERE (user_id = ? OR is_public) AND ` + strings.Join(match, " AND ") + `
+	ORDER BY rank DESC, id
+	LIMIT ?
+	`
+	args := append(rankArgs, userID)
+	args = append(args, matchArgs...)
+	args = append(args, limit)
+
+	rows, err := c.db.Query(sqlQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []VideoSearchResult{}
+	for rows.Next() {
+		var result VideoSearchResult
+		result.Video, err = scanVideo(extraScanner{rows, []any{&result.Rank}})
+		if err != nil {
+			return nil, err
+		}
+		result.TitleHighlight = markHighlights(highlightTerms(result.Video.Title, terms))
+		result.DescriptionSnippet = markHighlights(highlightTerms(snippetAround(result.Video.Description, terms, 16), terms))
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// highlightTerms wraps every occurrence of the terms in text in
+// highlightStart and highlightEnd, ignoring case
+func highlightTerms(text string, terms []string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		matched := 0
+		for _, term := range terms {
+			end := i + len(term)
+			if end <= len(text) && utf8.RuneStart(text[i]) && strings.EqualFold(text[i:end], term) {
+				matched = max(matched, len(term))
+			}
+		}
+		if matched == 0 {
+			b.WriteByte(text[i])
+			i++
+			continue
+		}
+		b.WriteString(highlightStart + text[i:i+matched] + highlightEnd)
+		i += matched
+	}
+	return b.String()
+}
+
+// snippetAround returns up to n words of text, starting a few words before
+// the first that contains one of the terms, like FTS5's snippet()
+func snippetAround(text string, terms []string, n int) string {
+	words := strings.Fields(text)
+	if len(words) <= n {
+		return strings.Join(words, " ")
+	}
+	first := 0
+search:
+	for i, word := range words {
+		for _, term := range terms {
+			if strings.Contains(strings.ToLower(word), strings.ToLower(term)) {
+				first = i
+				break search
+			}
+		}
+	}
+	start := min(max(first-3, 0), len(words)-n)
+	snippet := strings.Join(words[start:start+n], " ")
+	if start > 0 {
+		snippet = "…" + snippet
+	}
+	if start+n < len(words) {
+		snippet += "…"
+	}
+	return snippet
+}
+
+func markHighlights(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, highlightStart, "<mark>")
+	return strings.ReplaceAll(s, highlightEnd, "</mark>")
+}
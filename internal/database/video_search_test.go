@@ -0,0 +1,75 @@
+package database
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSearchVideos(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		owner := createUser(t, c, "a@example.com")
+		other := createUser(t, c, "b@example.com")
+		createVideo(t, c, owner, "Gopher tutorial", "Writing a <b>server</b> in Go")
+		createVideo(t, c, owner, "Cooking", "Nothing about it")
+		public := createVideo(t, c, other, "Gopher meetup", "Talks from the gopher meetup")
+		_, err := c.UpdateVideoMetadata(public.ID, nil, func(v *Video) { v.IsPublic = true })
+		if err != nil {
+			t.Fatalf("UpdateVideoMetadata: %v", err)
+		}
+		createVideo(t, c, other, "Gopher secrets", "gopher gopher gopher")
+
+		results, err := c.SearchVideos(owner, "goph", 10)
+		if err != nil {
+			t.Fatalf("SearchVideos: %v", err)
+		}
+		var titles []string
+		for _, result := range results {
+			titles = append(titles, result.Video.Title)
+		}
+		slices.Sort(titles)
+		if !slices.Equal(titles, []string{"Gopher meetup", "Gopher tutorial"}) {
+			t.Fatalf("found %v, want the user's own video and the public one", titles)
+		}
+		for _, result := range results {
+			if !strings.Contains(result.TitleHighlight, "<mark>") {
+				t.Errorf("title highlight %q has no match marked", result.TitleHighlight)
+			}
+			if result.Video.Title == "Gopher tutorial" && strings.Contains(result.DescriptionSnippet, "<b>") {
+				t.Errorf("description snippet %q isn't escaped", result.DescriptionSnippet)
+			}
+		}
+
+		// every word has to match
+		results, err = c.SearchVideos(owner, "gopher server", 10)
+		if err != nil {
+			t.Fatalf("SearchVideos: %v", err)
+		}
+		if len(results) != 1 || results[0].Video.Title != "Gopher tutorial" {
+			t.Fatalf("found %d results for two words, want the tutorial", len(results))
+		}
+
+		// the other user's private video ranks best, but the limit applies
+		// to what the user may see
+		results, err = c.SearchVideos(owner, "gopher", 1)
+		if err != nil {
+			t.Fatalf("SearchVideos: %v", err)
+		}
+		if len(results) != 1 || results[0].Video.Title == "Gopher secrets" {
+			t.Fatalf("found %+v with a limit of 1", results)
+		}
+
+		results, err = c.SearchVideos(owner, "  !!  ", 10)
+		if err != nil || len(results) != 0 {
+			t.Fatalf("search without words = %d results, %v", len(results), err)
+		}
+	})
+}
+
+func TestHighlightTerms(t *testing.T) {
+	got := markHighlights(highlightTerms("Go <go> GOPHER", []string{"go", "gopher"}))
+	want := "<mark>Go</mark> &lt;<mark>go</mark>&gt; <mark>GOPHER</mark>"
+	if got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
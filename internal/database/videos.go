@@ -2,18 +2,44 @@ package database
 
 import (
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type Video struct {
-	ID           uuid.UUID `json:"id"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	ThumbnailURL *string   `json:"thumbnail_url"`
-	VideoURL     *string   `json:"video_url"`
+	ID           uuid.UUID  `json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	ThumbnailURL *string    `json:"thumbnail_url"`
+	Thumbnails   Thumbnails `json:"thumbnails"`
+	// CustomThumbnail is set once the user uploads their own thumbnail, so
+	// frames extracted from the video no longer replace it
+	CustomThumbnail bool `json:"custom_thumbnail"`
+	// ThumbnailCandidates are frames taken from the video to pick from
+	ThumbnailCandidates ThumbnailCandidates `json:"thumbnail_candidates"`
+	VideoURL            *string             `json:"video_url"`
+	Width               *int                `json:"width"`
+	Height              *int                `json:"height"`
+	Duration            *float64            `json:"duration"`
+	Codec               *string             `json:"codec"`
+	Orientation         *string             `json:"orientation"`
+	// HLSManifestURL is the master playlist, set once transcoding finished
+	HLSManifestURL *string `json:"hls_manifest_url"`
+	// PreviewVTTURL is a WebVTT track of sprite sheet tiles for seek bar
+	// previews
+	PreviewVTTURL *string  `json:"preview_vtt_url"`
+	Captions      Captions `json:"captions"`
+	// IsPublic videos turn up in every user's search results
+	IsPublic bool        `json:"is_public"`
+	Status   VideoStatus `json:"status"`
+	// FailureReason says what went wrong when Status is VideoFailed
+	FailureReason *string `json:"failure_reason"`
 	CreateVideoParams
 }
 
@@ -23,47 +49,229 @@ type CreateVideoParams struct {
 	UserID      uuid.UUID `json:"user_id"`
 }
 
-func (c Client) GetVideos(userID uuid.UUID) ([]Video, error) {
-	query := `
-	SELECT
+// VideoStatus is where a video is in its lifecycle. It only moves along
+// the transitions in videoTransitions, see SetVideoStatus.
+type VideoStatus string
+
+const (
+	// VideoDraft has metadata but no file yet
+	VideoDraft VideoStatus = "draft"
+	// VideoUploading is receiving a file
+	VideoUploading VideoStatus = "uploading"
+	// VideoProcessing has its file and is being probed or transcoded
+	VideoProcessing VideoStatus = "processing"
+	VideoReady      VideoStatus = "ready"
+	VideoFailed     VideoStatus = "failed"
+)
+
+var ErrInvalidTransition = errors.New("invalid video status transition")
+
+var ErrVideoModified = errors.New("video was modified")
+
+// videoTransitions lists, for every status, the statuses a video may move
+// to it from. A new upload can start from anywhere and replaces the file.
+var videoTransitions = map[VideoStatus][]VideoStatus{
+	VideoUploading:  {VideoDraft, VideoUploading, VideoProcessing, VideoReady, VideoFailed},
+	VideoProcessing: {VideoUploading},
+	VideoReady:      {VideoProcessing},
+	VideoFailed:     {VideoUploading, VideoProcessing},
+}
+
+// Thumbnails maps a rendition name such as "480w" to its URL. It is stored
+// as a JSON object in the thumbnails column.
+type Thumbnails map[string]string
+
+func (t *Thumbnails) Scan(src any) error {
+	m := Thumbnails{}
+	if err := scanJSON(src, &m); err != nil {
+		return err
+	}
+	*t = m
+	return nil
+}
+
+func (t Thumbnails) Value() (driver.Value, error) {
+	if len(t) == 0 {
+		return nil, nil
+	}
+	raw, err := json.Marshal(map[string]string(t))
+	if err != nil {
+		return nil, err
+	}
+	return string(raw), nil
+}
+
+// ThumbnailCandidates is a list of image URLs, stored as a JSON array
+type ThumbnailCandidates []string
+
+func (t *ThumbnailCandidates) Scan(src any) error {
+	list := ThumbnailCandidates{}
+	if err := scanJSON(src, &list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+func (t ThumbnailCandidates) Value() (driver.Value, error) {
+	if len(t) == 0 {
+		return nil, nil
+	}
+	raw, err := json.Marshal([]string(t))
+	if err != nil {
+		return nil, err
+	}
+	return string(raw), nil
+}
+
+// Caption is a WebVTT caption track for one language
+type Caption struct {
+	Language string `json:"language"` // BCP-47 tag, e.g. "en-US"
+	Label    string `json:"label"`
+	URL      string `json:"url"`
+}
+
+// Captions are a video's caption tracks, at most one per language. They're
+// stored as a JSON array in the captions column.
+type Captions []Caption
+
+func (c *Captions) Scan(src any) error {
+	list := Captions{}
+	if err := scanJSON(src, &list); err != nil {
+		return err
+	}
+	*c = list
+	return nil
+}
+
+func (c Captions) Value() (driver.Value, error) {
+	if len(c) == 0 {
+		return nil, nil
+	}
+	raw, err := json.Marshal([]Caption(c))
+	if err != nil {
+		return nil, err
+	}
+	return string(raw), nil
+}
+
+// scanJSON decodes a JSON text column into dest, leaving dest alone for NULL
+func scanJSON(src any, dest any) error {
+	var raw []byte
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case string:
+		raw = []byte(v)
+	case []byte:
+		raw = v
+	default:
+		return fmt.Errorf("can't scan %T into %T", src, dest)
+	}
+	return json.Unmarshal(raw, dest)
+}
+
+// videoColumns is the column list every video SELECT uses, in the order
+// scanVideo expects
+const videoColumns = `
 		id,
 		created_at,
 		updated_at,
 		title,
 		description,
 		thumbnail_url,
+		thumbnails,
+		custom_thumbnail,
+		thumbnail_candidates,
 		video_url,
-		user_id
-	FROM videos
-	WHERE user_id = ?
-	ORDER BY created_at DESC
-	`
+		width,
+		height,
+		duration,
+		codec,
+		orientation,
+		hls_manifest_url,
+		preview_vtt_url,
+		captions,
+		is_public,
+		status,
+		failure_reason,
+		user_id`
 
-	rows, err := c.db.Query(query, userID)
-	if err != nil {
-		return nil, err
-	}
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVideo(row scanner) (Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID,
+		&video.CreatedAt,
+		&video.UpdatedAt,
+		&video.Title,
+		&video.Description,
+		&video.ThumbnailURL,
+		&video.Thumbnails,
+		&video.CustomThumbnail,
+		&video.ThumbnailCandidates,
+		&video.VideoURL,
+		&video.Width,
+		&video.Height,
+		&video.Duration,
+		&video.Codec,
+		&video.Orientation,
+		&video.HLSManifestURL,
+		&video.PreviewVTTURL,
+		&video.Captions,
+		&video.IsPublic,
+		&video.Status,
+		&video.FailureReason,
+		&video.UserID,
+	)
+	return video, err
+}
+
+func scanVideos(rows *sql.Rows) ([]Video, error) {
 	defer rows.Close()
 
 	videos := []Video{}
 	for rows.Next() {
-		var video Video
-		if err := rows.Scan(
-			&video.ID,
-			&video.CreatedAt,
-			&video.UpdatedAt,
-			&video.Title,
-			&video.Description,
-			&video.ThumbnailURL,
-			&video.VideoURL,
-			&video.UserID,
-		); err != nil {
+		video, err := scanVideo(rows)
+		if err != nil {
 			return nil, err
 		}
 		videos = append(videos, video)
 	}
+	return videos, rows.Err()
+}
+
+// GetAllVideos returns the videos of every user
+func (c Client) GetAllVideos() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	`
 
-	return videos, nil
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
+}
+
+// GetVideosWithDataThumbnails returns every video whose thumbnail is still
+// stored inline as a data URL
+func (c Client) GetVideosWithDataThumbnails() ([]Video, error) {
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE thumbnail_url LIKE 'data:%'
+	`
+
+	rows, err := c.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	return scanVideos(rows)
 }
 
 func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
@@ -75,10 +283,11 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 		updated_at,
 		title,
 		description,
+		status,
 		user_id
-	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?)
+	) VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?, ?, ?, ?)
 	`
-	_, err := c.db.Exec(query, id, params.Title, params.Description, params.UserID)
+	_, err := c.db.Exec(query, id, params.Title, params.Description, VideoDraft, params.UserID)
 	if err != nil {
 		return Video{}, err
 	}
@@ -88,29 +297,12 @@ func (c Client) CreateVideo(params CreateVideoParams) (Video, error) {
 
 func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	query := `
-	SELECT
-		id,
-		created_at,
-		updated_at,
-		title,
-		description,
-		thumbnail_url,
-		video_url,
-		user_id
+	SELECT` + videoColumns + `
 	FROM videos
 	WHERE id = ?
 	`
 
-	var video Video
-	err := c.db.QueryRow(query, id).Scan(
-		&video.ID,
-		&video.CreatedAt,
-		&video.UpdatedAt,
-		&video.Title,
-		&video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		&video.UserID)
+	video, err := scanVideo(c.db.QueryRow(query, id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return Video{}, nil
@@ -121,35 +313,412 @@ func (c Client) GetVideo(id uuid.UUID) (Video, error) {
 	return video, nil
 }
 
-func (c Client) UpdateVideo(video Video) error {
+// VideoFile describes a video's stored file, see SetVideoFile
+type VideoFile struct {
+	URL         string
+	Width       int
+	Height      int
+	Duration    float64
+	Codec       string
+	Orientation string
+}
+
+// SetVideoFile records a new file for a video and makes key, the blob it is
+// in, the video's file blob, leaving every other column alone. It returns
+// the blobs no video references anymore; nothing changes if the video was
+// deleted.
+func (c Client) SetVideoFile(id uuid.UUID, file VideoFile, key string) ([]Blob, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
 	query := `
 	UPDATE videos
 	SET
-		title = ?,
-		description = ?,
-		thumbnail_url = ?,
 		video_url = ?,
-		user_id = ?
+		width = ?,
+		height = ?,
+		duration = ?,
+		codec = ?,
+		orientation = ?,
+		updated_at = ?
 	WHERE id = ?
 	`
-
-	_, err := c.db.Exec(
+	result, err := tx.Exec(
 		query,
-		video.Title,
-		video.Description,
-		&video.ThumbnailURL,
-		&video.VideoURL,
-		video.UserID,
-		video.ID,
+		file.URL,
+		file.Width,
+		file.Height,
+		file.Duration,
+		file.Codec,
+		file.Orientation,
+		time.Now().UTC(),
+		id,
 	)
+	if err != nil {
+		return nil, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	released, err := setVideoBlobs(tx, id, BlobRoleVideo, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	return released, tx.Commit()
+}
+
+// SetVideoThumbnail records a video's thumbnail, whether the user uploaded
+// it, and keys, the blobs of its renditions, leaving every other column
+// alone. It returns the blobs no video references anymore.
+func (c Client) SetVideoThumbnail(id uuid.UUID, url string, thumbnails Thumbnails, custom bool, keys []string) ([]Blob, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE videos
+	SET thumbnail_url = ?, thumbnails = ?, custom_thumbnail = ?, updated_at = ?
+	WHERE id = ?
+	`
+	result, err := tx.Exec(query, url, thumbnails, custom, time.Now().UTC(), id)
+	if err != nil {
+		return nil, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	released, err := setVideoBlobs(tx, id, BlobRoleThumbnail, keys)
+	if err != nil {
+		return nil, err
+	}
+	return released, tx.Commit()
+}
+
+// UpdateVideoCaptions applies patch to a video's current caption tracks and
+// saves them, leaving every other column alone. patch also returns the keys
+// of the blobs the tracks are in, which become the video's caption blobs in
+// the same transaction, so uploads for different languages at the same time
+// keep each other's tracks. It returns the new tracks, nil if the video
+// doesn't exist, and the blobs no video references anymore.
+func (c Client) UpdateVideoCaptions(id uuid.UUID, patch func(Captions) (Captions, []string)) (Captions, []Blob, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	lock := ""
+	if tx.dialect == dialectPostgres {
+		lock = "FOR UPDATE"
+	}
+	var tracks Captions
+	err = tx.QueryRow("SELECT captions FROM videos WHERE id = ? "+lock, id).Scan(&tracks)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tracks, keys := patch(tracks)
+	query := `
+	UPDATE videos
+	SET captions = ?, updated_at = ?
+	WHERE id = ?
+	`
+	_, err = tx.Exec(query, tracks, time.Now().UTC(), id)
+	if err != nil {
+		return nil, nil, err
+	}
+	released, err := setVideoBlobs(tx, id, BlobRoleCaptions, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tracks, released, tx.Commit()
+}
+
+// UpdateVideoMetadata applies patch to the current title, description and
+// visibility of a video and saves them, leaving every other column alone. If
+// ifUpdatedAt is set and the video changed since then, nothing is saved
+// and ErrVideoModified is returned. The video is zero if it doesn't exist.
+func (c Client) UpdateVideoMetadata(id uuid.UUID, ifUpdatedAt *time.Time, patch func(*Video)) (Video, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return Video{}, err
+	}
+	defer tx.Rollback()
+
+	lock := ""
+	if tx.dialect == dialectPostgres {
+		lock = "FOR UPDATE"
+	}
+	query := `
+	SELECT` + videoColumns + `
+	FROM videos
+	WHERE id = ?
+	` + lock
+	video, err := scanVideo(tx.QueryRow(query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Video{}, nil
+		}
+		return Video{}, err
+	}
+	if ifUpdatedAt != nil && !video.UpdatedAt.Equal(*ifUpdatedAt) {
+		return Video{}, ErrVideoModified
+	}
+
+	patch(&video)
+	// Postgres keeps microseconds, and the caller may compare this with
+	// what a later read returns
+	video.UpdatedAt = time.Now().UTC().Truncate(time.Microsecond)
+	query = `
+	UPDATE videos
+	SET title = ?, description = ?, is_public = ?, updated_at = ?
+	WHERE id = ?
+	`
+	_, err = tx.Exec(query, video.Title, video.Description, video.IsPublic, video.UpdatedAt, id)
+	if err != nil {
+		return Video{}, err
+	}
+	return video, tx.Commit()
+}
+
+// SetVideoHLSManifestURL records the result of a transcode without touching
+// fields the user may have changed while it ran
+func (c Client) SetVideoHLSManifestURL(id uuid.UUID, url *string) error {
+	query := `
+	UPDATE videos
+	SET hls_manifest_url = ?, updated_at = ?
+	WHERE id = ?
+	`
+	_, err := c.db.Exec(query, url, time.Now().UTC(), id)
 	return err
 }
 
-func (c Client) DeleteVideo(id uuid.UUID) error {
+// FinishVideoTranscode records the HLS ladder built from the file at
+// source and makes the video ready, unless its file has been replaced since.
+// It reports whether the ladder was recorded. The status is left alone if
+// the video can't become ready, e.g. because a new upload started.
+func (c Client) FinishVideoTranscode(id uuid.UUID, source, manifestURL string) (bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
 	query := `
-	DELETE FROM videos
+	UPDATE videos
+	SET hls_manifest_url = ?, updated_at = ?
+	WHERE id = ? AND video_url = ?
+	`
+	result, err := tx.Exec(query, manifestURL, now, id, source)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+
+	from := videoTransitions[VideoReady]
+	query = `
+	UPDATE videos
+	SET status = ?, failure_reason = NULL, updated_at = ?
+	WHERE id = ? AND status IN (?` + strings.Repeat(", ?", len(from)-1) + `)
+	`
+	args := []any{VideoReady, now, id}
+	for _, s := range from {
+		args = append(args, s)
+	}
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// SetVideoPreviewVTTURL records the scrubbing preview track of a video
+func (c Client) SetVideoPreviewVTTURL(id uuid.UUID, url *string) error {
+	query := `
+	UPDATE videos
+	SET preview_vtt_url = ?, updated_at = ?
 	WHERE id = ?
 	`
-	_, err := c.db.Exec(query, id)
+	_, err := c.db.Exec(query, url, time.Now().UTC(), id)
 	return err
 }
+
+// SetVideoSourcePreviewVTTURL records the preview track generated from the
+// file at source, unless the video's file has been replaced since. It
+// reports whether the track was recorded.
+func (c Client) SetVideoSourcePreviewVTTURL(id uuid.UUID, source, url string) (bool, error) {
+	query := `
+	UPDATE videos
+	SET preview_vtt_url = ?, updated_at = ?
+	WHERE id = ? AND video_url = ?
+	`
+	result, err := c.db.Exec(query, url, time.Now().UTC(), id, source)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// SetVideoThumbnailCandidates records the frames extracted from the file at
+// source, with keys the blobs they are in, unless the video's file has been
+// replaced since. It returns the blobs no video references anymore and
+// whether the frames were recorded.
+func (c Client) SetVideoThumbnailCandidates(id uuid.UUID, source string, candidates ThumbnailCandidates, keys []string) ([]Blob, bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE videos
+	SET thumbnail_candidates = ?, updated_at = ?
+	WHERE id = ? AND video_url = ?
+	`
+	result, err := tx.Exec(query, candidates, time.Now().UTC(), id, source)
+	if err != nil {
+		return nil, false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return nil, false, err
+	}
+	released, err := setVideoBlobs(tx, id, BlobRoleThumbnailCandidate, keys)
+	if err != nil {
+		return nil, false, err
+	}
+	return released, true, tx.Commit()
+}
+
+// SetVideoExtractedThumbnail uses a frame from the file at source as the
+// video's thumbnail, with keys the blobs of its renditions, unless the user
+// has uploaded one or the file has been replaced in the meantime. It returns
+// the blobs no video references anymore and whether the thumbnail was set.
+func (c Client) SetVideoExtractedThumbnail(id uuid.UUID, source, url string, thumbnails Thumbnails, keys []string) ([]Blob, bool, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	UPDATE videos
+	SET thumbnail_url = ?, thumbnails = ?, updated_at = ?
+	WHERE id = ? AND video_url = ? AND NOT custom_thumbnail
+	`
+	result, err := tx.Exec(query, url, thumbnails, time.Now().UTC(), id, source)
+	if err != nil {
+		return nil, false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return nil, false, err
+	}
+	released, err := setVideoBlobs(tx, id, BlobRoleThumbnail, keys)
+	if err != nil {
+		return nil, false, err
+	}
+	return released, true, tx.Commit()
+}
+
+// SetVideoStatus moves a video to status, or returns ErrInvalidTransition
+// if it isn't allowed from the video's current status. The check and the
+// update are one statement so concurrent uploads can't race past it.
+// failureReason is only kept for VideoFailed.
+func (c Client) SetVideoStatus(id uuid.UUID, status VideoStatus, failureReason string) error {
+	from, ok := videoTransitions[status]
+	if !ok {
+		return fmt.Errorf("%w: nothing moves to %q", ErrInvalidTransition, status)
+	}
+
+	var reason *string
+	if status == VideoFailed {
+		reason = &failureReason
+	}
+
+	query := `
+	UPDATE videos
+	SET status = ?, failure_reason = ?, updated_at = ?
+	WHERE id = ? AND status IN (?` + strings.Repeat(", ?", len(from)-1) + `)
+	`
+	args := []any{status, reason, time.Now().UTC(), id}
+	for _, s := range from {
+		args = append(args, s)
+	}
+	result, err := c.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: video %s can't become %q", ErrInvalidTransition, id, status)
+	}
+	return nil
+}
+
+// DeleteVideo removes the video and its blob references. It returns the
+// blobs no other video uses; their rows are gone and the caller should
+// delete the objects.
+func (c Client) DeleteVideo(id uuid.UUID) ([]Blob, error) {
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT blob_key, role FROM video_blobs WHERE video_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	type reference struct{ key, role string }
+	var refs []reference
+	for rows.Next() {
+		var ref reference
+		if err := rows.Scan(&ref.key, &ref.role); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		refs = append(refs, ref)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	released := []Blob{}
+	for _, ref := range refs {
+		blobs, err := releaseVideoBlobs(tx, id, ref.role, []string{ref.key})
+		if err != nil {
+			return nil, err
+		}
+		released = append(released, blobs...)
+	}
+
+	query := `
+	DELETE FROM videos
+	WHERE id = ?
+	`
+	_, err = tx.Exec(query, id)
+	if err != nil {
+		return nil, err
+	}
+	return released, tx.Commit()
+}
@@ -0,0 +1,211 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestUpdateVideoMetadata(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		video := createVideo(t, c, createUser(t, c, "a@example.com"), "before", "description")
+		createBlob(t, c, "videos/a.mp4")
+		_, err := c.SetVideoFile(video.ID, VideoFile{URL: "https://example.com/a.mp4", Width: 1920, Height: 1080}, "videos/a.mp4")
+		if err != nil {
+			t.Fatalf("SetVideoFile: %v", err)
+		}
+		video, err = c.GetVideo(video.ID)
+		if err != nil {
+			t.Fatalf("GetVideo: %v", err)
+		}
+
+		updated, err := c.UpdateVideoMetadata(video.ID, &video.UpdatedAt, func(v *Video) {
+			v.Title = "after"
+			v.IsPublic = true
+		})
+		if err != nil {
+			t.Fatalf("UpdateVideoMetadata: %v", err)
+		}
+		got, err := c.GetVideo(video.ID)
+		if err != nil {
+			t.Fatalf("GetVideo: %v", err)
+		}
+		if got.Title != "after" || !got.IsPublic {
+			t.Fatalf("got title %q, public %v, want the patch saved", got.Title, got.IsPublic)
+		}
+		if got.VideoURL == nil || *got.VideoURL != "https://example.com/a.mp4" {
+			t.Fatalf("video_url = %v, want the file left alone", got.VideoURL)
+		}
+		if !got.UpdatedAt.Equal(updated.UpdatedAt) {
+			t.Fatalf("updated_at read back as %v, returned as %v", got.UpdatedAt, updated.UpdatedAt)
+		}
+
+		// a writer still holding the first version loses
+		_, err = c.UpdateVideoMetadata(video.ID, &video.UpdatedAt, func(v *Video) { v.Title = "stale" })
+		if !errors.Is(err, ErrVideoModified) {
+			t.Fatalf("stale update: got %v, want ErrVideoModified", err)
+		}
+		got, err = c.GetVideo(video.ID)
+		if err != nil {
+			t.Fatalf("GetVideo: %v", err)
+		}
+		if got.Title != "after" {
+			t.Fatalf("title = %q after a stale update", got.Title)
+		}
+	})
+}
+
+func TestFinishVideoTranscode(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		video := createVideo(t, c, createUser(t, c, "a@example.com"), "title", "")
+		createBlob(t, c, "videos/new.mp4")
+		_, err := c.SetVideoFile(video.ID, VideoFile{URL: "https://example.com/new.mp4"}, "videos/new.mp4")
+		if err != nil {
+			t.Fatalf("SetVideoFile: %v", err)
+		}
+		for _, status := range []VideoStatus{VideoUploading, VideoProcessing} {
+			if err := c.SetVideoStatus(video.ID, status, ""); err != nil {
+				t.Fatalf("SetVideoStatus(%s): %v", status, err)
+			}
+		}
+
+		// a job queued for the file this one replaced
+		ok, err := c.FinishVideoTranscode(video.ID, "https://example.com/old.mp4", "https://example.com/old/master.m3u8")
+		if err != nil {
+			t.Fatalf("FinishVideoTranscode: %v", err)
+		}
+		if ok {
+			t.Fatal("a transcode of the replaced file was recorded")
+		}
+		got, err := c.GetVideo(video.ID)
+		if err != nil {
+			t.Fatalf("GetVideo: %v", err)
+		}
+		if got.HLSManifestURL != nil || got.Status != VideoProcessing {
+			t.Fatalf("after a stale transcode: manifest %v, status %s", got.HLSManifestURL, got.Status)
+		}
+
+		ok, err = c.FinishVideoTranscode(video.ID, "https://example.com/new.mp4", "https://example.com/new/master.m3u8")
+		if err != nil || !ok {
+			t.Fatalf("FinishVideoTranscode = %v, %v, want it recorded", ok, err)
+		}
+		got, err = c.GetVideo(video.ID)
+		if err != nil {
+			t.Fatalf("GetVideo: %v", err)
+		}
+		if got.HLSManifestURL == nil || *got.HLSManifestURL != "https://example.com/new/master.m3u8" || got.Status != VideoReady {
+			t.Fatalf("after the transcode: manifest %v, status %s", got.HLSManifestURL, got.Status)
+		}
+	})
+}
+
+func TestUpdateVideoCaptions(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		video := createVideo(t, c, createUser(t, c, "a@example.com"), "title", "")
+		for _, key := range []string{"captions/en-1.vtt", "captions/en-2.vtt", "captions/fr.vtt"} {
+			createBlob(t, c, key)
+		}
+		// each patch only adds its own track to what is stored, like the
+		// upload handler, which keys tracks by the URL of their blob
+		setTrack := func(lang, key string) []Blob {
+			t.Helper()
+			_, released, err := c.UpdateVideoCaptions(video.ID, func(tracks Captions) (Captions, []string) {
+				next := Captions{}
+				keys := []string{key}
+				for _, track := range tracks {
+					if track.Language != lang {
+						next = append(next, track)
+						keys = append(keys, track.URL)
+					}
+				}
+				return append(next, Caption{Language: lang, URL: key}), keys
+			})
+			if err != nil {
+				t.Fatalf("UpdateVideoCaptions(%s): %v", lang, err)
+			}
+			return released
+		}
+
+		setTrack("en", "captions/en-1.vtt")
+		setTrack("fr", "captions/fr.vtt")
+		released := setTrack("en", "captions/en-2.vtt")
+		if len(released) != 1 || released[0].Key != "captions/en-1.vtt" {
+			t.Fatalf("released %+v, want the replaced English track", released)
+		}
+
+		got, err := c.GetVideo(video.ID)
+		if err != nil {
+			t.Fatalf("GetVideo: %v", err)
+		}
+		keys := map[string]string{}
+		for _, track := range got.Captions {
+			keys[track.Language] = track.URL
+		}
+		if len(keys) != 2 || keys["en"] != "captions/en-2.vtt" || keys["fr"] != "captions/fr.vtt" {
+			t.Fatalf("captions = %+v", got.Captions)
+		}
+
+		tracks, _, err := c.UpdateVideoCaptions(createVideo(t, c, video.UserID, "other", "").ID, func(tracks Captions) (Captions, []string) {
+			return tracks, nil
+		})
+		if err != nil || tracks == nil {
+			t.Fatalf("video without captions: %v, %v", tracks, err)
+		}
+	})
+}
+
+func TestExtractedThumbnailsNeedTheirSource(t *testing.T) {
+	forEachDialect(t, func(t *testing.T, c Client) {
+		video := createVideo(t, c, createUser(t, c, "a@example.com"), "title", "")
+		for _, key := range []string{"videos/new.mp4", "thumbnails/frame.jpg", "thumbnails/480w.jpg"} {
+			createBlob(t, c, key)
+		}
+		_, err := c.SetVideoFile(video.ID, VideoFile{URL: "https://example.com/new.mp4"}, "videos/new.mp4")
+		if err != nil {
+			t.Fatalf("SetVideoFile: %v", err)
+		}
+
+		// frames from the file this one replaced
+		_, ok, err := c.SetVideoThumbnailCandidates(video.ID, "https://example.com/old.mp4",
+			ThumbnailCandidates{"https://example.com/frame.jpg"}, []string{"thumbnails/frame.jpg"})
+		if err != nil || ok {
+			t.Fatalf("stale SetVideoThumbnailCandidates = %v, %v, want nothing written", ok, err)
+		}
+		_, ok, err = c.SetVideoExtractedThumbnail(video.ID, "https://example.com/old.mp4",
+			"https://example.com/480w.jpg", Thumbnails{"480w": "https://example.com/480w.jpg"}, []string{"thumbnails/480w.jpg"})
+		if err != nil || ok {
+			t.Fatalf("stale SetVideoExtractedThumbnail = %v, %v, want nothing written", ok, err)
+		}
+		got, err := c.GetVideo(video.ID)
+		if err != nil {
+			t.Fatalf("GetVideo: %v", err)
+		}
+		if len(got.ThumbnailCandidates) != 0 || got.ThumbnailURL != nil {
+			t.Fatalf("stale frames were recorded: candidates %v, thumbnail %v", got.ThumbnailCandidates, got.ThumbnailURL)
+		}
+		keys, err := c.GetVideoBlobs(video.ID)
+		if err != nil {
+			t.Fatalf("GetVideoBlobs: %v", err)
+		}
+		if len(keys) != 1 {
+			t.Fatalf("video references %v, want only its file", keys)
+		}
+
+		_, ok, err = c.SetVideoThumbnailCandidates(video.ID, "https://example.com/new.mp4",
+			ThumbnailCandidates{"https://example.com/frame.jpg"}, []string{"thumbnails/frame.jpg"})
+		if err != nil || !ok {
+			t.Fatalf("SetVideoThumbnailCandidates = %v, %v", ok, err)
+		}
+		_, ok, err = c.SetVideoExtractedThumbnail(video.ID, "https://example.com/new.mp4",
+			"https://example.com/480w.jpg", Thumbnails{"480w": "https://example.com/480w.jpg"}, []string{"thumbnails/480w.jpg"})
+		if err != nil || !ok {
+			t.Fatalf("SetVideoExtractedThumbnail = %v, %v", ok, err)
+		}
+		keys, err = c.GetVideoBlobs(video.ID)
+		if err != nil {
+			t.Fatalf("GetVideoBlobs: %v", err)
+		}
+		if len(keys) != 3 {
+			t.Fatalf("video references %v, want its file, frame and thumbnail", keys)
+		}
+	})
+}
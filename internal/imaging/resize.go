@@ -0,0 +1,114 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ThumbnailWidths are the renditions produced for every uploaded thumbnail
+var ThumbnailWidths = []int{160, 480, 1280}
+
+const jpegQuality = 85
+
+// MaxPixels caps the size of images Resize will decode. Compressed images
+// can be tiny and still decode to gigabytes, so the size in the header is
+// checked first.
+const MaxPixels = 50_000_000
+
+var ErrInvalidImage = errors.New("invalid or unsupported image")
+
+type Rendition struct {
+	Width     int
+	Height    int
+	Data      []byte
+	MediaType string
+}
+
+// Name is the key used for the rendition in srcset style maps, e.g. "480w"
+func (r Rendition) Name() string {
+	return fmt.Sprintf("%dw", r.Width)
+}
+
+// Ext is the file extension matching the rendition's media type
+func (r Rendition) Ext() string {
+	if r.MediaType == "image/png" {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// Resize decodes a PNG, JPEG or WebP image and scales it down to each of
+// widths, keeping the aspect ratio. Widths larger than the source are
+// skipped; if all of them are, a single rendition at the source width is
+// returned so there is always something to show. PNGs stay PNG to keep
+// transparency, everything else is encoded as JPEG. Images over MaxPixels
+// are rejected before being decoded.
+func Resize(data []byte, widths []int) ([]Rendition, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if format != "png" && format != "jpeg" && format != "webp" {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidImage, format)
+	}
+	if int64(config.Width)*int64(config.Height) > MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d is over %d pixels", ErrInvalidImage, config.Width, config.Height, MaxPixels)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	bounds := src.Bounds()
+	if bounds.Dx() == 0 || bounds.Dy() == 0 {
+		return nil, fmt.Errorf("%w: image has no pixels", ErrInvalidImage)
+	}
+
+	targets := []int{}
+	for _, w := range widths {
+		if w <= bounds.Dx() {
+			targets = append(targets, w)
+		}
+	}
+	if len(targets) == 0 {
+		targets = append(targets, bounds.Dx())
+	}
+
+	renditions := make([]Rendition, 0, len(targets))
+	for _, width := range targets {
+		height := bounds.Dy() * width / bounds.Dx()
+		if height < 1 {
+			height = 1
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+
+		var buf bytes.Buffer
+		mediaType := "image/jpeg"
+		if format == "png" {
+			mediaType = "image/png"
+			err = png.Encode(&buf, dst)
+		} else {
+			err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		renditions = append(renditions, Rendition{
+			Width:     width,
+			Height:    height,
+			Data:      buf.Bytes(),
+			MediaType: mediaType,
+		})
+	}
+	return renditions, nil
+}
@@ -0,0 +1,136 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// testImage encodes a width x height gradient as PNG or JPEG
+func testImage(t *testing.T, format string, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	var err error
+	if format == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// pngWithSize is a 1x1 PNG whose header claims width x height, to test the
+// size check without allocating a huge image
+func pngWithSize(t *testing.T, width, height uint32) []byte {
+	t.Helper()
+	data := testImage(t, "png", 1, 1)
+	// the IHDR chunk follows the 8 byte signature: length, type, then the
+	// width and height, and its CRC over type and data
+	ihdr := data[8+4 : 8+4+4+13]
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	binary.BigEndian.PutUint32(data[8+4+4+13:], crc32.ChecksumIEEE(ihdr))
+	return data
+}
+
+func TestResize(t *testing.T) {
+	tests := []struct {
+		name          string
+		format        string
+		width, height int
+		want          [][2]int
+		mediaType     string
+	}{
+		{"png", "png", 1600, 900, [][2]int{{160, 90}, {480, 270}, {1280, 720}}, "image/png"},
+		{"jpeg", "jpeg", 2000, 1000, [][2]int{{160, 80}, {480, 240}, {1280, 640}}, "image/jpeg"},
+		{"portrait", "jpeg", 700, 1400, [][2]int{{160, 320}, {480, 960}}, "image/jpeg"},
+		{"exact width", "png", 1280, 720, [][2]int{{160, 90}, {480, 270}, {1280, 720}}, "image/png"},
+		{"smaller than the largest", "png", 600, 300, [][2]int{{160, 80}, {480, 240}}, "image/png"},
+		{"smaller than every width", "jpeg", 100, 50, [][2]int{{100, 50}}, "image/jpeg"},
+		{"very wide", "png", 2000, 4, [][2]int{{160, 1}, {480, 1}, {1280, 2}}, "image/png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renditions, err := Resize(testImage(t, tt.format, tt.width, tt.height), ThumbnailWidths)
+			if err != nil {
+				t.Fatalf("Resize: %v", err)
+			}
+			if len(renditions) != len(tt.want) {
+				t.Fatalf("got %d renditions, want %d", len(renditions), len(tt.want))
+			}
+			for i, r := range renditions {
+				if r.Width != tt.want[i][0] || r.Height != tt.want[i][1] {
+					t.Errorf("rendition %d is %dx%d, want %dx%d", i, r.Width, r.Height, tt.want[i][0], tt.want[i][1])
+				}
+				if r.MediaType != tt.mediaType {
+					t.Errorf("rendition %d is %s, want %s", i, r.MediaType, tt.mediaType)
+				}
+				// the data is an image of the size it claims
+				config, format, err := image.DecodeConfig(bytes.NewReader(r.Data))
+				if err != nil {
+					t.Fatalf("rendition %d doesn't decode: %v", i, err)
+				}
+				if "image/"+format != r.MediaType || config.Width != r.Width || config.Height != r.Height {
+					t.Errorf("rendition %d decodes as a %dx%d %s", i, config.Width, config.Height, format)
+				}
+			}
+		})
+	}
+}
+
+func TestRenditionNames(t *testing.T) {
+	r := Rendition{Width: 480, MediaType: "image/png"}
+	if r.Name() != "480w" || r.Ext() != ".png" {
+		t.Fatalf("got %s%s, want 480w.png", r.Name(), r.Ext())
+	}
+	r.MediaType = "image/jpeg"
+	if r.Ext() != ".jpg" {
+		t.Fatalf("got %s for a JPEG", r.Ext())
+	}
+}
+
+func TestResizeRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"not an image", []byte("hello, world")},
+		{"truncated", testImage(t, "png", 100, 100)[:60]},
+		{"over the pixel cap", pngWithSize(t, 10_000, 5_001)},
+		{"over the pixel cap by height", pngWithSize(t, 1, MaxPixels+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Resize(tt.data, ThumbnailWidths)
+			if !errors.Is(err, ErrInvalidImage) {
+				t.Fatalf("got %v, want ErrInvalidImage", err)
+			}
+		})
+	}
+}
+
+func TestPixelCapIsCheckedBeforeDecoding(t *testing.T) {
+	// decoding this would allocate 200 MB before finding the pixel data
+	// missing, so failing fast means the header was checked
+	_, err := Resize(pngWithSize(t, 10_000, 5_001), ThumbnailWidths)
+	if err == nil || !strings.Contains(err.Error(), "pixels") {
+		t.Fatalf("an image over the cap wasn't rejected for its size: %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// DefaultMaxAttempts is how many times a job runs before it is dead lettered
+const DefaultMaxAttempts = 5
+
+// Store is the slice of the database a Queue needs
+type Store interface {
+	EnqueueJob(params database.EnqueueJobParams) (database.Job, error)
+	LeaseJob(leaseFor time.Duration) (*database.Job, error)
+	ExtendJobLease(id uuid.UUID, until time.Time) error
+	CompleteJob(id uuid.UUID) error
+	RetryJob(id uuid.UUID, runAt time.Time, lastError string) error
+	KillJob(id uuid.UUID, lastError string) error
+}
+
+// Handler runs one job. Returning an error schedules a retry unless the job
+// is out of attempts; wrap the error with Permanent to give up right away.
+type Handler func(ctx context.Context, payload json.RawMessage) error
+
+// DeadHandler is told about a job that failed for good, with its last error
+type DeadHandler func(payload json.RawMessage, err error)
+
+// Queue persists jobs in the database and runs them on a pool of workers.
+// Jobs survive restarts, and a job whose worker dies is picked up again
+// once its lease runs out.
+type Queue struct {
+	store        Store
+	handlers     map[string]Handler
+	dead         map[string]DeadHandler
+	workers      int
+	lease        time.Duration
+	pollInterval time.Duration
+}
+
+func NewQueue(store Store, workers int) *Queue {
+	return &Queue{
+		store:        store,
+		handlers:     map[string]Handler{},
+		dead:         map[string]DeadHandler{},
+		workers:      max(workers, 1),
+		lease:        5 * time.Minute,
+		pollInterval: time.Second,
+	}
+}
+
+// Handle registers the handler for a job type. Call it before Run.
+func (q *Queue) Handle(jobType string, h Handler) {
+	q.handlers[jobType] = h
+}
+
+// OnDead registers a callback for jobs of a type that are dead lettered.
+// Call it before Run.
+func (q *Queue) OnDead(jobType string, h DeadHandler) {
+	q.dead[jobType] = h
+}
+
+// Enqueue stores a job to run as soon as a worker is free. payload is
+// marshalled to JSON.
+func (q *Queue) Enqueue(jobType string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	_, err = q.store.EnqueueJob(database.EnqueueJobParams{
+		Type:        jobType,
+		Payload:     data,
+		MaxAttempts: DefaultMaxAttempts,
+	})
+	return err
+}
+
+// Run starts the workers and blocks until ctx is cancelled and every
+// running job has returned
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range q.workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		job, err := q.store.LeaseJob(q.lease)
+		if err != nil {
+			log.Printf("couldn't lease job: %v", err)
+		}
+		if job != nil {
+			q.run(ctx, *job)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(q.pollInterval):
+		}
+	}
+}
+
+func (q *Queue) run(ctx context.Context, job database.Job) {
+	// a lease that ran out while the job was still running elsewhere can
+	// push attempts past the limit
+	if job.Attempts > job.MaxAttempts {
+		q.finish(job, fmt.Errorf("gave up after %d attempts", job.MaxAttempts))
+		return
+	}
+
+	h, ok := q.handlers[job.Type]
+	if !ok {
+		q.finish(job, Permanent(fmt.Errorf("no handler for job type %q", job.Type)))
+		return
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		q.keepLease(jobCtx, job.ID)
+		close(done)
+	}()
+	err := h(jobCtx, job.Payload)
+	cancel()
+	<-done
+
+	// shutting down mid-job: leave it leased so it runs again after restart
+	if ctx.Err() != nil {
+		return
+	}
+	q.finish(job, err)
+}
+
+// keepLease renews the lease until ctx is cancelled so long jobs aren't
+// handed to a second worker
+func (q *Queue) keepLease(ctx context.Context, id uuid.UUID) {
+	ticker := time.NewTicker(q.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := q.store.ExtendJobLease(id, time.Now().Add(q.lease))
+			if err != nil {
+				log.Printf("couldn't extend lease on job %s: %v", id, err)
+			}
+		}
+	}
+}
+
+func (q *Queue) finish(job database.Job, jobErr error) {
+	var err error
+	switch {
+	case jobErr == nil:
+		err = q.store.CompleteJob(job.ID)
+	case isPermanent(jobErr) || job.Attempts >= job.MaxAttempts:
+		log.Printf("job %s (%s) failed for good: %v", job.ID, job.Type, jobErr)
+		err = q.store.KillJob(job.ID, jobErr.Error())
+		if h, ok := q.dead[job.Type]; ok {
+			h(job.Payload, jobErr)
+		}
+	default:
+		delay := Backoff(job.Attempts)
+		log.Printf("job %s (%s) failed, retrying in %s: %v", job.ID, job.Type, delay, jobErr)
+		err = q.store.RetryJob(job.ID, time.Now().Add(delay), jobErr.Error())
+	}
+	if err != nil {
+		log.Printf("couldn't record result of job %s: %v", job.ID, err)
+	}
+}
+
+// Backoff is the delay before retrying a job that has failed attempts
+// times: 30s, 1m, 2m, ... capped at an hour
+func Backoff(attempts int) time.Duration {
+	delay := 30 * time.Second
+	for i := 1; i < attempts && delay < time.Hour; i++ {
+		delay *= 2
+	}
+	return min(delay, time.Hour)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as not worth retrying
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+func isPermanent(err error) bool {
+	var perm permanentError
+	return errors.As(err, &perm)
+}
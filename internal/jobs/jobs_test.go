@@ -0,0 +1,322 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/google/uuid"
+)
+
+// fakeStore keeps jobs in memory and records what the queue did with them
+type fakeStore struct {
+	mu        sync.Mutex
+	jobs      []*database.Job
+	completed []uuid.UUID
+	retried   map[uuid.UUID]time.Time
+	killed    map[uuid.UUID]string
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		retried: map[uuid.UUID]time.Time{},
+		killed:  map[uuid.UUID]string{},
+	}
+}
+
+func (s *fakeStore) EnqueueJob(params database.EnqueueJobParams) (database.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := &database.Job{
+		ID:               uuid.New(),
+		Status:           database.JobQueued,
+		RunAt:            time.Now(),
+		EnqueueJobParams: params,
+	}
+	s.jobs = append(s.jobs, job)
+	return *job, nil
+}
+
+func (s *fakeStore) LeaseJob(leaseFor time.Duration) (*database.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		if job.Status == database.JobQueued && !job.RunAt.After(time.Now()) {
+			job.Status = database.JobRunning
+			job.Attempts++
+			leased := *job
+			return &leased, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *fakeStore) ExtendJobLease(id uuid.UUID, until time.Time) error {
+	return nil
+}
+
+func (s *fakeStore) CompleteJob(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.completed = append(s.completed, id)
+	return nil
+}
+
+func (s *fakeStore) RetryJob(id uuid.UUID, runAt time.Time, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retried[id] = runAt
+	return nil
+}
+
+func (s *fakeStore) KillJob(id uuid.UUID, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.killed[id] = lastError
+	return nil
+}
+
+// deadCall is one call of a DeadHandler
+type deadCall struct {
+	payload json.RawMessage
+	err     error
+}
+
+// runJob runs a single leased job through q the way a worker does and
+// returns what the DeadHandler for its type was called with
+func runJob(t *testing.T, q *Queue, attempts int, h Handler) (database.Job, []deadCall) {
+	t.Helper()
+	job := database.Job{
+		ID:       uuid.New(),
+		Status:   database.JobRunning,
+		Attempts: attempts,
+		EnqueueJobParams: database.EnqueueJobParams{
+			Type:        "test",
+			Payload:     json.RawMessage(`{"n":1}`),
+			MaxAttempts: 3,
+		},
+	}
+	var calls []deadCall
+	if h != nil {
+		q.Handle("test", h)
+	}
+	q.OnDead("test", func(payload json.RawMessage, err error) {
+		calls = append(calls, deadCall{payload, err})
+	})
+	q.run(context.Background(), job)
+	return job, calls
+}
+
+func TestRunCompletes(t *testing.T) {
+	store := newFakeStore()
+	q := NewQueue(store, 1)
+	job, dead := runJob(t, q, 1, func(ctx context.Context, payload json.RawMessage) error {
+		if string(payload) != `{"n":1}` {
+			t.Errorf("handler got payload %s", payload)
+		}
+		return nil
+	})
+	if len(store.completed) != 1 || store.completed[0] != job.ID {
+		t.Fatalf("completed %v, want the job", store.completed)
+	}
+	if len(store.retried) != 0 || len(store.killed) != 0 || len(dead) != 0 {
+		t.Fatalf("a successful job was retried or killed")
+	}
+}
+
+func TestRunRetriesTransientErrors(t *testing.T) {
+	store := newFakeStore()
+	q := NewQueue(store, 1)
+	before := time.Now()
+	job, dead := runJob(t, q, 2, func(ctx context.Context, payload json.RawMessage) error {
+		return errors.New("connection reset")
+	})
+	runAt, ok := store.retried[job.ID]
+	if !ok {
+		t.Fatal("job wasn't retried")
+	}
+	// the second attempt failed, so the third waits Backoff(2)
+	if runAt.Before(before.Add(Backoff(2))) || runAt.After(time.Now().Add(Backoff(2))) {
+		t.Fatalf("retry at %v, want %v from now", runAt, Backoff(2))
+	}
+	if len(store.killed) != 0 || len(dead) != 0 {
+		t.Fatal("a job with attempts left was dead lettered")
+	}
+}
+
+func TestRunDeadLetters(t *testing.T) {
+	failure := errors.New("boom")
+	tests := []struct {
+		name     string
+		attempts int
+		handler  Handler
+		// wantErr is what the DeadHandler has to get, checked with errors.Is
+		wantErr error
+	}{
+		{
+			name:     "permanent error",
+			attempts: 1,
+			handler: func(ctx context.Context, payload json.RawMessage) error {
+				return Permanent(failure)
+			},
+			wantErr: failure,
+		},
+		{
+			name:     "wrapped permanent error",
+			attempts: 1,
+			handler: func(ctx context.Context, payload json.RawMessage) error {
+				return errors.Join(errors.New("while transcoding"), Permanent(failure))
+			},
+			wantErr: failure,
+		},
+		{
+			name:     "last attempt",
+			attempts: 3,
+			handler: func(ctx context.Context, payload json.RawMessage) error {
+				return failure
+			},
+			wantErr: failure,
+		},
+		{
+			name:     "lease ran out past the last attempt",
+			attempts: 4,
+			handler: func(ctx context.Context, payload json.RawMessage) error {
+				t.Error("handler ran after the job was out of attempts")
+				return nil
+			},
+		},
+		{
+			name:     "no handler",
+			attempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newFakeStore()
+			q := NewQueue(store, 1)
+			job, dead := runJob(t, q, tt.attempts, tt.handler)
+
+			lastError, ok := store.killed[job.ID]
+			if !ok {
+				t.Fatal("job wasn't dead lettered")
+			}
+			if len(store.retried) != 0 || len(store.completed) != 0 {
+				t.Fatal("a dead lettered job was retried or completed")
+			}
+			if len(dead) != 1 {
+				t.Fatalf("DeadHandler called %d times, want once", len(dead))
+			}
+			if string(dead[0].payload) != `{"n":1}` {
+				t.Fatalf("DeadHandler got payload %s", dead[0].payload)
+			}
+			if dead[0].err == nil || dead[0].err.Error() != lastError {
+				t.Fatalf("DeadHandler got %v, the job recorded %q", dead[0].err, lastError)
+			}
+			if tt.wantErr != nil && !errors.Is(dead[0].err, tt.wantErr) {
+				t.Fatalf("DeadHandler got %v, want %v", dead[0].err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{4, 4 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{1000, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := Backoff(tt.attempts); got != tt.want {
+			t.Errorf("Backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestQueueRun(t *testing.T) {
+	store := newFakeStore()
+	q := NewQueue(store, 2)
+	q.pollInterval = time.Millisecond
+
+	done := make(chan string, 1)
+	q.Handle("greet", func(ctx context.Context, payload json.RawMessage) error {
+		var name string
+		if err := json.Unmarshal(payload, &name); err != nil {
+			return Permanent(err)
+		}
+		done <- name
+		return nil
+	})
+	err := q.Enqueue("greet", "gopher")
+	if err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if store.jobs[0].MaxAttempts != DefaultMaxAttempts {
+		t.Fatalf("enqueued with %d attempts, want %d", store.jobs[0].MaxAttempts, DefaultMaxAttempts)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	select {
+	case name := <-done:
+		if name != "gopher" {
+			t.Fatalf("handler got %q", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job didn't run")
+	}
+	// the job is completed after the handler returns
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		store.mu.Lock()
+		completed := len(store.completed)
+		store.mu.Unlock()
+		if completed == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job wasn't completed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestShutdownLeavesJobLeased(t *testing.T) {
+	store := newFakeStore()
+	q := NewQueue(store, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	q.Handle("test", func(jobCtx context.Context, payload json.RawMessage) error {
+		cancel()
+		<-jobCtx.Done()
+		return jobCtx.Err()
+	})
+	job := database.Job{
+		ID:               uuid.New(),
+		Attempts:         1,
+		EnqueueJobParams: database.EnqueueJobParams{Type: "test", MaxAttempts: 3},
+	}
+	q.run(ctx, job)
+	if len(store.completed) != 0 || len(store.retried) != 0 || len(store.killed) != 0 {
+		t.Fatal("a job interrupted by shutdown was finished; it should run again after a restart")
+	}
+}
@@ -0,0 +1,141 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mp4"
+)
+
+const (
+	OrientationLandscape = "landscape"
+	OrientationPortrait  = "portrait"
+	OrientationOther     = "other"
+)
+
+var ErrInvalidVideo = errors.New("invalid video")
+
+type Info struct {
+	Width    int
+	Height   int
+	Duration float64 // seconds
+	Codec    string
+}
+
+// Orientation classifies the display aspect ratio as 16:9 landscape, 9:16
+// portrait or anything else
+func (i Info) Orientation() string {
+	if i.Width <= 0 || i.Height <= 0 {
+		return OrientationOther
+	}
+	ratio := float64(i.Width) / float64(i.Height)
+	const tolerance = 0.02
+	switch {
+	case math.Abs(ratio-16.0/9.0) < tolerance:
+		return OrientationLandscape
+	case math.Abs(ratio-9.0/16.0) < tolerance:
+		return OrientationPortrait
+	default:
+		return OrientationOther
+	}
+}
+
+// Probe inspects the video at path with ffprobe when it is on PATH and
+// falls back to reading the MP4 boxes directly otherwise
+func Probe(ctx context.Context, path string) (Info, error) {
+	if _, err := exec.LookPath("ffprobe"); err == nil {
+		return probeFFprobe(ctx, path)
+	}
+	return probeMP4(path)
+}
+
+func probeMP4(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return Info{}, err
+	}
+	info, err := mp4.Probe(f, stat.Size())
+	if err != nil {
+		return Info{}, fmt.Errorf("%w: %v", ErrInvalidVideo, err)
+	}
+	return Info(info), nil
+}
+
+func probeFFprobe(ctx context.Context, path string) (Info, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe",
+		"-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height,codec_name,duration:stream_tags=rotate:stream_side_data=rotation:format=duration",
+		"-of", "json",
+		path,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return Info{}, fmt.Errorf("%w: ffprobe: %v: %s", ErrInvalidVideo, err, stderr.String())
+	}
+
+	var output struct {
+		Streams []struct {
+			Width     int    `json:"width"`
+			Height    int    `json:"height"`
+			CodecName string `json:"codec_name"`
+			Duration  string `json:"duration"`
+			Tags      struct {
+				Rotate string `json:"rotate"`
+			} `json:"tags"`
+			SideDataList []struct {
+				Rotation float64 `json:"rotation"`
+			} `json:"side_data_list"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	err = json.Unmarshal(stdout.Bytes(), &output)
+	if err != nil {
+		return Info{}, fmt.Errorf("couldn't parse ffprobe output: %w", err)
+	}
+	if len(output.Streams) == 0 {
+		return Info{}, fmt.Errorf("%w: no video stream", ErrInvalidVideo)
+	}
+
+	stream := output.Streams[0]
+	info := Info{
+		Width:  stream.Width,
+		Height: stream.Height,
+		Codec:  stream.CodecName,
+	}
+	info.Duration, _ = strconv.ParseFloat(stream.Duration, 64)
+	if info.Duration == 0 {
+		info.Duration, _ = strconv.ParseFloat(output.Format.Duration, 64)
+	}
+
+	// ffprobe reports the coded size, so apply the rotation ourselves
+	rotation, _ := strconv.ParseFloat(stream.Tags.Rotate, 64)
+	for _, sd := range stream.SideDataList {
+		if sd.Rotation != 0 {
+			rotation = sd.Rotation
+		}
+	}
+	if int(math.Abs(rotation))%180 == 90 {
+		info.Width, info.Height = info.Height, info.Width
+	}
+
+	return info, nil
+}
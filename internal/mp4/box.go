@@ -0,0 +1,167 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrInvalid = errors.New("invalid mp4")
+
+// maxMoovSize guards against reading an absurd moov box into memory
+const maxMoovSize = 64 << 20
+
+// HasFileType reports whether head, the first bytes of a file, starts with
+// the ftyp box every MP4 opens with. It is a cheap check before fetching
+// the whole file.
+func HasFileType(head []byte) bool {
+	if len(head) < 8 {
+		return false
+	}
+	return string(head[4:8]) == "ftyp" && binary.BigEndian.Uint32(head[0:4]) >= 8
+}
+
+// box is an ISO BMFF box located in a file or in a parent box's payload
+type box struct {
+	typ        string
+	offset     int64 // offset of the box header
+	headerSize int64
+	size       int64 // total size including the header
+}
+
+// readBoxHeader reads the header of the box starting at offset. fileSize is
+// used for boxes that extend to the end of the file.
+func readBoxHeader(r io.ReaderAt, offset, fileSize int64) (box, error) {
+	var hdr [16]byte
+	_, err := r.ReadAt(hdr[:8], offset)
+	if err != nil {
+		return box{}, err
+	}
+	b := box{
+		typ:        string(hdr[4:8]),
+		offset:     offset,
+		headerSize: 8,
+		size:       int64(binary.BigEndian.Uint32(hdr[0:4])),
+	}
+	switch b.size {
+	case 0:
+		b.size = fileSize - offset
+	case 1:
+		_, err = r.ReadAt(hdr[8:16], offset+8)
+		if err != nil {
+			return box{}, err
+		}
+		b.headerSize = 16
+		b.size = int64(binary.BigEndian.Uint64(hdr[8:16]))
+	}
+	if b.size < b.headerSize || offset+b.size > fileSize {
+		return box{}, fmt.Errorf("%w: bad size for %q box at %d", ErrInvalid, b.typ, offset)
+	}
+	return b, nil
+}
+
+// topLevelBoxes lists the boxes at the root of the file
+func topLevelBoxes(r io.ReaderAt, fileSize int64) ([]box, error) {
+	boxes := []box{}
+	for offset := int64(0); offset < fileSize; {
+		if fileSize-offset < 8 {
+			return nil, fmt.Errorf("%w: trailing bytes at %d", ErrInvalid, offset)
+		}
+		b, err := readBoxHeader(r, offset, fileSize)
+		if err != nil {
+			return nil, err
+		}
+		boxes = append(boxes, b)
+		offset += b.size
+	}
+	return boxes, nil
+}
+
+// children lists the boxes inside payload, which is the body of a
+// container box
+func children(payload []byte) ([]box, error) {
+	boxes := []box{}
+	size := int64(len(payload))
+	r := bytesReaderAt(payload)
+	for offset := int64(0); offset < size; {
+		if size-offset < 8 {
+			return nil, fmt.Errorf("%w: trailing bytes in container", ErrInvalid)
+		}
+		b, err := readBoxHeader(r, offset, size)
+		if err != nil {
+			return nil, err
+		}
+		boxes = append(boxes, b)
+		offset += b.size
+	}
+	return boxes, nil
+}
+
+// body returns the payload of b, which must have come from children(payload)
+func (b box) body(payload []byte) []byte {
+	return payload[b.offset+b.headerSize : b.offset+b.size]
+}
+
+// find returns the payload of the first child of the given type
+func find(payload []byte, typ string) ([]byte, bool, error) {
+	boxes, err := children(payload)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b.body(payload), true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// findPath walks nested containers, e.g. findPath(trak, "mdia", "minf")
+func findPath(payload []byte, path ...string) ([]byte, bool, error) {
+	for _, typ := range path {
+		var ok bool
+		var err error
+		payload, ok, err = find(payload, typ)
+		if err != nil || !ok {
+			return nil, ok, err
+		}
+	}
+	return payload, true, nil
+}
+
+// readMoov locates the moov box and returns its header and payload
+func readMoov(r io.ReaderAt, fileSize int64) (box, []byte, error) {
+	boxes, err := topLevelBoxes(r, fileSize)
+	if err != nil {
+		return box{}, nil, err
+	}
+	for _, b := range boxes {
+		if b.typ != "moov" {
+			continue
+		}
+		if b.size > maxMoovSize {
+			return box{}, nil, fmt.Errorf("%w: moov box too large", ErrInvalid)
+		}
+		payload := make([]byte, b.size-b.headerSize)
+		_, err = r.ReadAt(payload, b.offset+b.headerSize)
+		if err != nil {
+			return box{}, nil, err
+		}
+		return b, payload, nil
+	}
+	return box{}, nil, fmt.Errorf("%w: no moov box", ErrInvalid)
+}
+
+type bytesReaderAt []byte
+
+func (b bytesReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off >= int64(len(b)) {
+		return 0, io.EOF
+	}
+	n := copy(p, b[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,192 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mkbox builds a box of the given type around its payload
+func mkbox(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := make([]byte, 8, 8+len(body))
+	binary.BigEndian.PutUint32(b[0:4], uint32(8+len(body)))
+	copy(b[4:8], typ)
+	return append(b, body...)
+}
+
+func u32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func u64(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+// tkhd builds a version 0 track header with the given transform matrix
+// coefficients a and b and display size
+func tkhd(a, b int32, width, height int) []byte {
+	payload := make([]byte, 84)
+	matrix := payload[40:76]
+	binary.BigEndian.PutUint32(matrix[0:4], uint32(a))
+	binary.BigEndian.PutUint32(matrix[4:8], uint32(b))
+	binary.BigEndian.PutUint32(matrix[12:16], uint32(-b))
+	binary.BigEndian.PutUint32(matrix[16:20], uint32(a))
+	binary.BigEndian.PutUint32(matrix[32:36], 0x40000000)
+	binary.BigEndian.PutUint32(payload[76:80], uint32(width)<<16)
+	binary.BigEndian.PutUint32(payload[80:84], uint32(height)<<16)
+	return mkbox("tkhd", payload)
+}
+
+// mdhd builds a version 0 media header
+func mdhd(timescale, duration uint32) []byte {
+	payload := make([]byte, 24)
+	binary.BigEndian.PutUint32(payload[12:16], timescale)
+	binary.BigEndian.PutUint32(payload[16:20], duration)
+	return mkbox("mdhd", payload)
+}
+
+func hdlr(handler string) []byte {
+	payload := make([]byte, 25)
+	copy(payload[8:12], handler)
+	return mkbox("hdlr", payload)
+}
+
+func stsd(format string) []byte {
+	entry := mkbox(format, make([]byte, 78))
+	return mkbox("stsd", u32(0), u32(1), entry)
+}
+
+func stco(offsets ...uint32) []byte {
+	payload := [][]byte{u32(0), u32(uint32(len(offsets)))}
+	for _, o := range offsets {
+		payload = append(payload, u32(o))
+	}
+	return mkbox("stco", payload...)
+}
+
+func co64(offsets ...uint64) []byte {
+	payload := [][]byte{u32(0), u32(uint32(len(offsets)))}
+	for _, o := range offsets {
+		payload = append(payload, u64(o))
+	}
+	return mkbox("co64", payload...)
+}
+
+// testFile is a small MP4 with a video track using stco and an audio track
+// using co64, both pointing at chunks in mdat
+type testFile struct {
+	data   []byte
+	chunks [][]byte
+	moov   []byte
+}
+
+// newTestFile lays out ftyp, mdat and moov, with moov first if fastStart
+func newTestFile(fastStart bool, a, b int32) testFile {
+	ftyp := mkbox("ftyp", []byte("isom"), u32(0x200), []byte("isomavc1"))
+	chunks := [][]byte{
+		bytes.Repeat([]byte{0xAA}, 24),
+		bytes.Repeat([]byte{0xBB}, 40),
+	}
+
+	// moov's size doesn't depend on the offsets in it, so build it once to
+	// measure and again with the real offsets
+	build := func(first, second uint32) []byte {
+		video := mkbox("trak",
+			tkhd(a, b, 640, 360),
+			mkbox("mdia",
+				mdhd(1000, 5000),
+				hdlr("vide"),
+				mkbox("minf", mkbox("stbl", stsd("avc1"), stco(first, second))),
+			),
+		)
+		audio := mkbox("trak",
+			mkbox("mdia",
+				mdhd(48000, 240000),
+				hdlr("soun"),
+				mkbox("minf", mkbox("stbl", stsd("mp4a"), co64(uint64(first)))),
+			),
+		)
+		return mkbox("moov", video, audio)
+	}
+	moovSize := len(build(0, 0))
+
+	mdatOffset := len(ftyp)
+	if fastStart {
+		mdatOffset += moovSize
+	}
+	first := uint32(mdatOffset + 8)
+	second := first + uint32(len(chunks[0]))
+	moov := build(first, second)
+	mdat := mkbox("mdat", chunks...)
+
+	var data []byte
+	if fastStart {
+		data = bytes.Join([][]byte{ftyp, moov, mdat}, nil)
+	} else {
+		data = bytes.Join([][]byte{ftyp, mdat, moov}, nil)
+	}
+	return testFile{data: data, chunks: chunks, moov: moov}
+}
+
+// chunkOffsets returns the stco and co64 entries of every track, in order
+func chunkOffsets(t *testing.T, data []byte) (stcoOffsets, co64Offsets []int64) {
+	t.Helper()
+	_, moov, err := readMoov(bytesReaderAt(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("readMoov: %v", err)
+	}
+	boxes, err := children(moov)
+	if err != nil {
+		t.Fatalf("children: %v", err)
+	}
+	for _, b := range boxes {
+		if b.typ != "trak" {
+			continue
+		}
+		stbl, ok, err := findPath(b.body(moov), "mdia", "minf", "stbl")
+		if err != nil || !ok {
+			t.Fatalf("trak without stbl: %v", err)
+		}
+		if table, ok, _ := find(stbl, "stco"); ok {
+			for i := 0; i < int(binary.BigEndian.Uint32(table[4:8])); i++ {
+				stcoOffsets = append(stcoOffsets, int64(binary.BigEndian.Uint32(table[8+4*i:])))
+			}
+		}
+		if table, ok, _ := find(stbl, "co64"); ok {
+			for i := 0; i < int(binary.BigEndian.Uint32(table[4:8])); i++ {
+				co64Offsets = append(co64Offsets, int64(binary.BigEndian.Uint64(table[8+8*i:])))
+			}
+		}
+	}
+	return stcoOffsets, co64Offsets
+}
+
+func topLevelTypes(t *testing.T, data []byte) []string {
+	t.Helper()
+	boxes, err := topLevelBoxes(bytesReaderAt(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("topLevelBoxes: %v", err)
+	}
+	types := []string{}
+	for _, b := range boxes {
+		types = append(types, b.typ)
+	}
+	return types
+}
+
+// sampleFile opens a video downloaded by samplesdownload.sh, skipping the
+// test if it isn't there
+func sampleFile(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "..", "samples", name))
+	if os.IsNotExist(err) {
+		t.Skipf("%s not found, run samplesdownload.sh", name)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
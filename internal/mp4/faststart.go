@@ -0,0 +1,167 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ErrOffsetOverflow means a 32 bit stco entry would no longer fit once
+// moov is moved forward. The file is still playable as is.
+var ErrOffsetOverflow = errors.New("chunk offset overflows stco")
+
+// IsFastStart reports whether the moov box already comes before the first
+// mdat box, so a player can start before the whole file has arrived
+func IsFastStart(r io.ReaderAt, size int64) (bool, error) {
+	boxes, err := topLevelBoxes(r, size)
+	if err != nil {
+		return false, err
+	}
+	for _, b := range boxes {
+		switch b.typ {
+		case "moov":
+			return true, nil
+		case "mdat":
+			return false, nil
+		}
+	}
+	return false, fmt.Errorf("%w: no moov box", ErrInvalid)
+}
+
+// FastStart writes a copy of src to dst with the moov box moved in front of
+// the first mdat box. Chunk offsets in every stco and co64 box are shifted
+// to match the new position of the media data. Files that are already fast
+// start are copied unchanged.
+func FastStart(dst io.Writer, src io.ReaderAt, size int64) error {
+	boxes, err := topLevelBoxes(src, size)
+	if err != nil {
+		return err
+	}
+
+	moovIndex, mdatIndex := -1, -1
+	for i, b := range boxes {
+		if b.typ == "moov" && moovIndex == -1 {
+			moovIndex = i
+		}
+		if b.typ == "mdat" && mdatIndex == -1 {
+			mdatIndex = i
+		}
+	}
+	if moovIndex == -1 {
+		return fmt.Errorf("%w: no moov box", ErrInvalid)
+	}
+	if mdatIndex == -1 || moovIndex < mdatIndex {
+		_, err = io.Copy(dst, io.NewSectionReader(src, 0, size))
+		return err
+	}
+
+	moov, payload, err := readMoov(src, size)
+	if err != nil {
+		return err
+	}
+	// written fresh rather than copied, a size of 0 ("runs to the end of
+	// the file") stops being true once moov is moved
+	header := make([]byte, moov.headerSize)
+	copy(header[4:8], "moov")
+	if moov.headerSize == 16 {
+		binary.BigEndian.PutUint32(header[0:4], 1)
+		binary.BigEndian.PutUint64(header[8:16], uint64(moov.size))
+	} else {
+		binary.BigEndian.PutUint32(header[0:4], uint32(moov.size))
+	}
+
+	// everything from the first mdat up to the old moov slides back by the
+	// size of moov, data after the old moov stays where it was
+	insertAt := boxes[mdatIndex].offset
+	err = shiftChunkOffsets(payload, insertAt, moov.offset, moov.size)
+	if err != nil {
+		return err
+	}
+
+	for i, b := range boxes {
+		if i == mdatIndex {
+			if _, err := dst.Write(header); err != nil {
+				return err
+			}
+			if _, err := dst.Write(payload); err != nil {
+				return err
+			}
+		}
+		if i == moovIndex {
+			continue
+		}
+		_, err = io.Copy(dst, io.NewSectionReader(src, b.offset, b.size))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// containers are the boxes that have to be walked to reach stco and co64
+var containers = map[string]bool{
+	"moov": true,
+	"trak": true,
+	"mdia": true,
+	"minf": true,
+	"stbl": true,
+}
+
+// shiftChunkOffsets adds delta to every chunk offset in [from, to) found
+// anywhere below payload. The payload is modified in place.
+func shiftChunkOffsets(payload []byte, from, to, delta int64) error {
+	boxes, err := children(payload)
+	if err != nil {
+		return err
+	}
+	for _, b := range boxes {
+		body := b.body(payload)
+		switch {
+		case containers[b.typ]:
+			err = shiftChunkOffsets(body, from, to, delta)
+		case b.typ == "stco":
+			err = shiftTable(body, 4, from, to, delta)
+		case b.typ == "co64":
+			err = shiftTable(body, 8, from, to, delta)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shiftTable rewrites the entries of an stco (width 4) or co64 (width 8)
+// payload: a full box header, an entry count, then the offsets
+func shiftTable(body []byte, width int, from, to, delta int64) error {
+	if len(body) < 8 {
+		return fmt.Errorf("%w: short chunk offset box", ErrInvalid)
+	}
+	count := int(binary.BigEndian.Uint32(body[4:8]))
+	entries := body[8:]
+	if count < 0 || len(entries) < count*width {
+		return fmt.Errorf("%w: chunk offset box shorter than its entry count", ErrInvalid)
+	}
+	for i := 0; i < count; i++ {
+		entry := entries[i*width : (i+1)*width]
+		if width == 4 {
+			offset := int64(binary.BigEndian.Uint32(entry))
+			if offset < from || offset >= to {
+				continue
+			}
+			if offset+delta > math.MaxUint32 {
+				return ErrOffsetOverflow
+			}
+			binary.BigEndian.PutUint32(entry, uint32(offset+delta))
+			continue
+		}
+		offset := int64(binary.BigEndian.Uint64(entry))
+		if offset < from || offset >= to {
+			continue
+		}
+		binary.BigEndian.PutUint64(entry, uint64(offset+delta))
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package mp4
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+func TestFastStartMovesMoov(t *testing.T) {
+	file := newTestFile(false, 0x10000, 0)
+	size := int64(len(file.data))
+
+	fast, err := IsFastStart(bytesReaderAt(file.data), size)
+	if err != nil {
+		t.Fatalf("IsFastStart: %v", err)
+	}
+	if fast {
+		t.Fatal("moov after mdat reported as fast start")
+	}
+
+	var out bytes.Buffer
+	err = FastStart(&out, bytesReaderAt(file.data), size)
+	if err != nil {
+		t.Fatalf("FastStart: %v", err)
+	}
+	result := out.Bytes()
+
+	if len(result) != len(file.data) {
+		t.Fatalf("size changed from %d to %d", len(file.data), len(result))
+	}
+	if got, want := topLevelTypes(t, result), []string{"ftyp", "moov", "mdat"}; !slices.Equal(got, want) {
+		t.Fatalf("boxes are %v, want %v", got, want)
+	}
+	fast, err = IsFastStart(bytesReaderAt(result), int64(len(result)))
+	if err != nil || !fast {
+		t.Fatalf("IsFastStart after rewrite = %v, %v", fast, err)
+	}
+
+	moovSize := int64(len(file.moov))
+	oldStco, oldCo64 := chunkOffsets(t, file.data)
+	newStco, newCo64 := chunkOffsets(t, result)
+	for i := range oldStco {
+		if newStco[i] != oldStco[i]+moovSize {
+			t.Errorf("stco[%d] = %d, want %d", i, newStco[i], oldStco[i]+moovSize)
+		}
+	}
+	for i := range oldCo64 {
+		if newCo64[i] != oldCo64[i]+moovSize {
+			t.Errorf("co64[%d] = %d, want %d", i, newCo64[i], oldCo64[i]+moovSize)
+		}
+	}
+
+	// the offsets have to land on the same media data as before
+	for i, chunk := range file.chunks {
+		offset := newStco[i]
+		if got := result[offset : offset+int64(len(chunk))]; !bytes.Equal(got, chunk) {
+			t.Errorf("stco[%d] points at %x, want %x", i, got, chunk)
+		}
+	}
+	if got := result[newCo64[0] : newCo64[0]+int64(len(file.chunks[0]))]; !bytes.Equal(got, file.chunks[0]) {
+		t.Errorf("co64[0] points at %x, want %x", got, file.chunks[0])
+	}
+}
+
+func TestFastStartLeavesFastStartFiles(t *testing.T) {
+	file := newTestFile(true, 0x10000, 0)
+
+	fast, err := IsFastStart(bytesReaderAt(file.data), int64(len(file.data)))
+	if err != nil || !fast {
+		t.Fatalf("IsFastStart = %v, %v", fast, err)
+	}
+
+	var out bytes.Buffer
+	err = FastStart(&out, bytesReaderAt(file.data), int64(len(file.data)))
+	if err != nil {
+		t.Fatalf("FastStart: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), file.data) {
+		t.Fatal("fast start file was changed")
+	}
+}
+
+func TestFastStartRejectsFilesWithoutMoov(t *testing.T) {
+	data := append(mkbox("ftyp", []byte("isom"), u32(0)), mkbox("mdat", make([]byte, 16))...)
+	var out bytes.Buffer
+	err := FastStart(&out, bytesReaderAt(data), int64(len(data)))
+	if err == nil {
+		t.Fatal("expected an error for a file without moov")
+	}
+}
+
+func TestFastStartSamples(t *testing.T) {
+	for _, name := range []string{"boots-video-horizontal.mp4", "boots-video-vertical.mp4"} {
+		t.Run(name, func(t *testing.T) {
+			data := sampleFile(t, name)
+
+			var out bytes.Buffer
+			err := FastStart(&out, bytesReaderAt(data), int64(len(data)))
+			if err != nil {
+				t.Fatalf("FastStart: %v", err)
+			}
+			result := out.Bytes()
+			if len(result) != len(data) {
+				t.Fatalf("size changed from %d to %d", len(data), len(result))
+			}
+			fast, err := IsFastStart(bytesReaderAt(result), int64(len(result)))
+			if err != nil || !fast {
+				t.Fatalf("IsFastStart after rewrite = %v, %v", fast, err)
+			}
+
+			before, err := Probe(bytesReaderAt(data), int64(len(data)))
+			if err != nil {
+				t.Fatalf("Probe before: %v", err)
+			}
+			after, err := Probe(bytesReaderAt(result), int64(len(result)))
+			if err != nil {
+				t.Fatalf("Probe after: %v", err)
+			}
+			if before != after {
+				t.Fatalf("probe changed from %+v to %+v", before, after)
+			}
+
+			// every chunk still starts with the same bytes
+			oldStco, oldCo64 := chunkOffsets(t, data)
+			newStco, newCo64 := chunkOffsets(t, result)
+			old := append(oldStco, oldCo64...)
+			moved := append(newStco, newCo64...)
+			for i := range old {
+				end := min(old[i]+16, int64(len(data)))
+				if !bytes.Equal(data[old[i]:end], result[moved[i]:moved[i]+end-old[i]]) {
+					t.Fatalf("chunk %d moved from %d to %d but its data didn't follow", i, old[i], moved[i])
+				}
+			}
+		})
+	}
+}
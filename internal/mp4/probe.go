@@ -0,0 +1,144 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Info is what Probe can tell about the first video track
+type Info struct {
+	Width    int
+	Height   int
+	Duration float64 // seconds
+	Codec    string
+}
+
+// codecNames maps sample entry types to the names ffprobe reports
+var codecNames = map[string]string{
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"av01": "av1",
+	"vp09": "vp9",
+	"mp4v": "mpeg4",
+}
+
+// Probe reads the moov box and reports the display size, duration and
+// codec of the first video track. The track matrix is applied, so a phone
+// video recorded sideways with a 90 degree rotation reports portrait size.
+func Probe(r io.ReaderAt, fileSize int64) (Info, error) {
+	_, moov, err := readMoov(r, fileSize)
+	if err != nil {
+		return Info{}, err
+	}
+
+	boxes, err := children(moov)
+	if err != nil {
+		return Info{}, err
+	}
+	for _, b := range boxes {
+		if b.typ != "trak" {
+			continue
+		}
+		trak := b.body(moov)
+		hdlr, ok, err := findPath(trak, "mdia", "hdlr")
+		if err != nil {
+			return Info{}, err
+		}
+		if !ok || len(hdlr) < 12 || string(hdlr[8:12]) != "vide" {
+			continue
+		}
+		return probeVideoTrak(trak, moov)
+	}
+	return Info{}, fmt.Errorf("%w: no video track", ErrInvalid)
+}
+
+func probeVideoTrak(trak, moov []byte) (Info, error) {
+	info := Info{}
+
+	tkhd, ok, err := find(trak, "tkhd")
+	if err != nil {
+		return Info{}, err
+	}
+	if !ok {
+		return Info{}, fmt.Errorf("%w: video track without tkhd", ErrInvalid)
+	}
+	// version 1 uses 64 bit times, which moves everything after them
+	matrixOffset := 40
+	if len(tkhd) > 0 && tkhd[0] == 1 {
+		matrixOffset = 52
+	}
+	if len(tkhd) < matrixOffset+36+8 {
+		return Info{}, fmt.Errorf("%w: short tkhd", ErrInvalid)
+	}
+	matrix := tkhd[matrixOffset : matrixOffset+36]
+	info.Width = int(binary.BigEndian.Uint32(tkhd[matrixOffset+36:]) >> 16)
+	info.Height = int(binary.BigEndian.Uint32(tkhd[matrixOffset+40:]) >> 16)
+	// a zero a-coefficient with a non-zero b means a 90 or 270 degree turn
+	a := int32(binary.BigEndian.Uint32(matrix[0:4]))
+	b := int32(binary.BigEndian.Uint32(matrix[4:8]))
+	if a == 0 && b != 0 {
+		info.Width, info.Height = info.Height, info.Width
+	}
+
+	mdhd, ok, err := findPath(trak, "mdia", "mdhd")
+	if err != nil {
+		return Info{}, err
+	}
+	if ok {
+		info.Duration = parseDuration(mdhd)
+	}
+	if info.Duration == 0 {
+		mvhd, ok, err := find(moov, "mvhd")
+		if err != nil {
+			return Info{}, err
+		}
+		if ok {
+			info.Duration = parseDuration(mvhd)
+		}
+	}
+
+	stsd, ok, err := findPath(trak, "mdia", "minf", "stbl", "stsd")
+	if err != nil {
+		return Info{}, err
+	}
+	// full box header, entry count, then the first sample entry's header
+	if ok && len(stsd) >= 16 {
+		fourcc := string(stsd[12:16])
+		info.Codec = fourcc
+		if name, ok := codecNames[fourcc]; ok {
+			info.Codec = name
+		}
+	}
+
+	return info, nil
+}
+
+// parseDuration reads timescale and duration from an mvhd or mdhd payload,
+// which share the same layout up to the duration field
+func parseDuration(payload []byte) float64 {
+	if len(payload) < 4 {
+		return 0
+	}
+	var timescale uint32
+	var duration uint64
+	if payload[0] == 1 {
+		if len(payload) < 32 {
+			return 0
+		}
+		timescale = binary.BigEndian.Uint32(payload[20:24])
+		duration = binary.BigEndian.Uint64(payload[24:32])
+	} else {
+		if len(payload) < 20 {
+			return 0
+		}
+		timescale = binary.BigEndian.Uint32(payload[12:16])
+		duration = uint64(binary.BigEndian.Uint32(payload[16:20]))
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return float64(duration) / float64(timescale)
+}
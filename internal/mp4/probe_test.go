@@ -0,0 +1,57 @@
+package mp4
+
+import (
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	file := newTestFile(false, 0x10000, 0)
+	info, err := Probe(bytesReaderAt(file.data), int64(len(file.data)))
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	want := Info{Width: 640, Height: 360, Duration: 5, Codec: "h264"}
+	if info != want {
+		t.Fatalf("Probe = %+v, want %+v", info, want)
+	}
+}
+
+func TestProbeRotated(t *testing.T) {
+	// a 90 degree rotation, as phones write for portrait recordings
+	file := newTestFile(true, 0, 0x10000)
+	info, err := Probe(bytesReaderAt(file.data), int64(len(file.data)))
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if info.Width != 360 || info.Height != 640 {
+		t.Fatalf("rotated video is %dx%d, want 360x640", info.Width, info.Height)
+	}
+}
+
+func TestProbeSamples(t *testing.T) {
+	tests := []struct {
+		name     string
+		portrait bool
+	}{
+		{"boots-video-horizontal.mp4", false},
+		{"boots-video-vertical.mp4", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := sampleFile(t, tt.name)
+			info, err := Probe(bytesReaderAt(data), int64(len(data)))
+			if err != nil {
+				t.Fatalf("Probe: %v", err)
+			}
+			if info.Width == 0 || info.Height == 0 || info.Duration <= 0 {
+				t.Fatalf("incomplete probe: %+v", info)
+			}
+			if portrait := info.Height > info.Width; portrait != tt.portrait {
+				t.Fatalf("%dx%d, want portrait = %v", info.Width, info.Height, tt.portrait)
+			}
+			if info.Codec != "h264" {
+				t.Fatalf("codec = %q, want h264", info.Codec)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// put stores body at key in l or fails the test
+func put(t *testing.T, l *Local, key, body string) {
+	t.Helper()
+	err := l.Put(context.Background(), key, strings.NewReader(body), ContentType(key))
+	if err != nil {
+		t.Fatalf("Put(%q): %v", key, err)
+	}
+}
+
+func TestLocalPutGet(t *testing.T) {
+	l := NewLocal(t.TempDir(), "/assets/")
+	ctx := context.Background()
+	put(t, l, "landscape/abc.mp4", "first")
+	// a second Put replaces the object
+	put(t, l, "landscape/abc.mp4", "video")
+
+	body, info, err := l.Get(ctx, "landscape/abc.mp4")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "video" {
+		t.Fatalf("got %q, want %q", data, "video")
+	}
+	if info.Key != "landscape/abc.mp4" || info.Size != 5 || info.ContentType != "video/mp4" {
+		t.Fatalf("got %+v", info)
+	}
+
+	stat, err := l.Stat(ctx, "landscape/abc.mp4")
+	if err != nil || stat.Size != 5 {
+		t.Fatalf("Stat = %+v, %v", stat, err)
+	}
+
+	rng, err := l.GetRange(ctx, "landscape/abc.mp4", 1, 3)
+	if err != nil {
+		t.Fatalf("GetRange: %v", err)
+	}
+	data, err = io.ReadAll(rng)
+	rng.Close()
+	if err != nil || string(data) != "ide" {
+		t.Fatalf("GetRange read %q, %v, want %q", data, err, "ide")
+	}
+
+	if got := l.URL("landscape/abc.mp4"); got != "/assets/landscape/abc.mp4" {
+		t.Fatalf("URL = %s", got)
+	}
+}
+
+func TestLocalMissing(t *testing.T) {
+	l := NewLocal(t.TempDir(), "/assets")
+	ctx := context.Background()
+	put(t, l, "hls/abc/master.m3u8", "#EXTM3U")
+
+	_, _, err := l.Get(ctx, "landscape/missing.mp4")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get = %v, want ErrNotFound", err)
+	}
+	_, err = l.GetRange(ctx, "landscape/missing.mp4", 0, 1)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRange = %v, want ErrNotFound", err)
+	}
+	_, err = l.Stat(ctx, "landscape/missing.mp4")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat = %v, want ErrNotFound", err)
+	}
+	// directories aren't objects
+	_, err = l.Stat(ctx, "hls/abc")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat of a directory = %v, want ErrNotFound", err)
+	}
+}
+
+func TestLocalDelete(t *testing.T) {
+	root := t.TempDir()
+	l := NewLocal(root, "/assets")
+	ctx := context.Background()
+	put(t, l, "hls/abc/720p/index.m3u8", "#EXTM3U")
+	put(t, l, "hls/def/master.m3u8", "#EXTM3U")
+
+	err := l.Delete(ctx, "hls/abc/720p/index.m3u8")
+	if err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	_, err = l.Stat(ctx, "hls/abc/720p/index.m3u8")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Stat after Delete = %v, want ErrNotFound", err)
+	}
+	// the emptied directories are removed, up to the first one in use
+	if _, err := os.Stat(filepath.Join(root, "hls", "abc")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("empty directory left behind: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "hls", "def", "master.m3u8")); err != nil {
+		t.Fatalf("a neighbouring object went missing: %v", err)
+	}
+	if _, err := os.Stat(root); err != nil {
+		t.Fatalf("the root was removed: %v", err)
+	}
+
+	// deleting what isn't there is fine
+	err = l.Delete(ctx, "hls/abc/720p/index.m3u8")
+	if err != nil {
+		t.Fatalf("second Delete: %v", err)
+	}
+}
+
+func TestLocalList(t *testing.T) {
+	root := t.TempDir()
+	l := NewLocal(root, "/assets")
+	ctx := context.Background()
+	for _, key := range []string{
+		"landscape/abc.mp4",
+		"landscape/abd.mp4",
+		"portrait/abc.mp4",
+		"hls/abc/master.m3u8",
+		"hls/abc/720p/index.m3u8",
+	} {
+		put(t, l, key, "x")
+	}
+	// a Put in progress isn't listed
+	err := os.WriteFile(filepath.Join(root, "landscape", ".tmp-123"), []byte("x"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"", []string{"hls/abc/720p/index.m3u8", "hls/abc/master.m3u8", "landscape/abc.mp4", "landscape/abd.mp4", "portrait/abc.mp4"}},
+		{"landscape/", []string{"landscape/abc.mp4", "landscape/abd.mp4"}},
+		{"landscape/abc", []string{"landscape/abc.mp4"}},
+		{"hls/abc/", []string{"hls/abc/720p/index.m3u8", "hls/abc/master.m3u8"}},
+		{"land", []string{"landscape/abc.mp4", "landscape/abd.mp4"}},
+		{"other/", []string{}},
+	}
+	for _, tt := range tests {
+		objects, err := l.List(ctx, tt.prefix)
+		if err != nil {
+			t.Errorf("List(%q): %v", tt.prefix, err)
+			continue
+		}
+		keys := []string{}
+		for _, object := range objects {
+			keys = append(keys, object.Key)
+		}
+		slices.Sort(keys)
+		if !slices.Equal(keys, tt.want) {
+			t.Errorf("List(%q) = %v, want %v", tt.prefix, keys, tt.want)
+		}
+	}
+}
+
+func TestLocalRejectsInvalidKeys(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "assets")
+	l := NewLocal(root, "/assets")
+	ctx := context.Background()
+	put(t, l, "landscape/abc.mp4", "x")
+	// something outside the root the keys below could reach
+	err := os.WriteFile(filepath.Join(filepath.Dir(root), "secret"), []byte("x"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []string{
+		"",
+		"..",
+		"../secret",
+		"landscape/../../secret",
+		"landscape/../abc.mp4",
+		"./landscape/abc.mp4",
+		"/etc/passwd",
+		"landscape\\abc.mp4",
+		"landscape//abc.mp4",
+	}
+	for _, key := range keys {
+		err := l.Put(ctx, key, strings.NewReader("x"), "")
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		_, _, err = l.Get(ctx, key)
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		_, err = l.GetRange(ctx, key, 0, 1)
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("GetRange(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		_, err = l.Stat(ctx, key)
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Stat(%q) = %v, want ErrInvalidKey", key, err)
+		}
+		err = l.Delete(ctx, key)
+		if !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q) = %v, want ErrInvalidKey", key, err)
+		}
+	}
+	_, err = l.List(ctx, "../")
+	if !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("List(\"../\") = %v, want ErrInvalidKey", err)
+	}
+
+	// nothing outside the root was touched
+	if _, err := os.Stat(filepath.Join(filepath.Dir(root), "secret")); err != nil {
+		t.Fatalf("a file outside the root went missing: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "landscape", "abc.mp4")); err != nil {
+		t.Fatalf("an object went missing: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+)
+
+// FramePositions are where in a video thumbnail candidates are taken, as
+// fractions of its duration. The ends are skipped since they're often
+// black or a title card.
+var FramePositions = []float64{0.1, 0.5, 0.9}
+
+// Frames saves one JPEG per position in FramePositions into outDir and
+// returns their paths in the same order
+func Frames(ctx context.Context, input, outDir string, duration float64) ([]string, error) {
+	if !Available() {
+		return nil, ErrNoFFmpeg
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("invalid duration %v", duration)
+	}
+
+	paths := make([]string, 0, len(FramePositions))
+	for i, position := range FramePositions {
+		path := filepath.Join(outDir, fmt.Sprintf("frame_%d.jpg", i))
+		err := run(ctx,
+			"-y",
+			// seeking before -i is fast and accurate enough for a still
+			"-ss", fmt.Sprintf("%.3f", duration*position),
+			"-i", input,
+			"-frames:v", "1",
+			"-q:v", "2",
+			path,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't extract frame at %.0f%%: %w", position*100, err)
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
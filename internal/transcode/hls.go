@@ -0,0 +1,128 @@
+package transcode
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var ErrNoFFmpeg = errors.New("ffmpeg not found on PATH")
+
+// Rendition is one rung of the adaptive bitrate ladder. Height is the short
+// side of the frame, so a portrait 720p rendition is 720 pixels wide.
+type Rendition struct {
+	Name         string
+	Height       int
+	VideoBitrate int // bits per second
+	AudioBitrate int // bits per second
+}
+
+var Renditions = []Rendition{
+	{Name: "240p", Height: 240, VideoBitrate: 400_000, AudioBitrate: 64_000},
+	{Name: "480p", Height: 480, VideoBitrate: 1_400_000, AudioBitrate: 96_000},
+	{Name: "720p", Height: 720, VideoBitrate: 2_800_000, AudioBitrate: 128_000},
+	{Name: "1080p", Height: 1080, VideoBitrate: 5_000_000, AudioBitrate: 128_000},
+}
+
+// MasterPlaylist is the name of the playlist HLS writes into outDir
+const MasterPlaylist = "master.m3u8"
+
+// segmentSeconds is the target HLS segment length
+const segmentSeconds = 6
+
+// Available reports whether ffmpeg can be run
+func Available() bool {
+	_, err := exec.LookPath("ffmpeg")
+	return err == nil
+}
+
+// HLS transcodes input into an HLS ladder under outDir: one directory per
+// rendition holding index.m3u8 and its segments, plus MasterPlaylist
+// pointing at all of them. Renditions larger than the source are skipped,
+// except that the smallest one is always produced.
+func HLS(ctx context.Context, input, outDir string, width, height int) error {
+	if !Available() {
+		return ErrNoFFmpeg
+	}
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("invalid source size %dx%d", width, height)
+	}
+
+	portrait := height > width
+	shortSide := min(width, height)
+
+	var master strings.Builder
+	master.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for i, rendition := range Renditions {
+		if rendition.Height > shortSide && i > 0 {
+			break
+		}
+
+		// scale the short side and keep the aspect ratio, rounded to even
+		// numbers as libx264 requires
+		w, h := evenScale(width, height, rendition.Height, portrait)
+		scale := fmt.Sprintf("scale=%d:%d", w, h)
+
+		dir := filepath.Join(outDir, rendition.Name)
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return err
+		}
+		err = run(ctx,
+			"-y",
+			"-i", input,
+			"-map", "0:v:0",
+			"-map", "0:a:0?",
+			"-vf", scale,
+			"-c:v", "libx264",
+			"-preset", "veryfast",
+			"-b:v", fmt.Sprint(rendition.VideoBitrate),
+			"-maxrate", fmt.Sprint(rendition.VideoBitrate*107/100),
+			"-bufsize", fmt.Sprint(rendition.VideoBitrate*3/2),
+			// fixed GOP so segments in every rendition line up
+			"-g", "48",
+			"-keyint_min", "48",
+			"-sc_threshold", "0",
+			"-c:a", "aac",
+			"-b:a", fmt.Sprint(rendition.AudioBitrate),
+			"-ac", "2",
+			"-f", "hls",
+			"-hls_time", fmt.Sprint(segmentSeconds),
+			"-hls_playlist_type", "vod",
+			"-hls_segment_filename", filepath.Join(dir, "segment_%04d.ts"),
+			filepath.Join(dir, "index.m3u8"),
+		)
+		if err != nil {
+			return fmt.Errorf("couldn't transcode %s: %w", rendition.Name, err)
+		}
+
+		fmt.Fprintf(&master, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\n%s/index.m3u8\n",
+			rendition.VideoBitrate+rendition.AudioBitrate, w, h, rendition.Name)
+	}
+
+	return os.WriteFile(filepath.Join(outDir, MasterPlaylist), []byte(master.String()), 0644)
+}
+
+func evenScale(width, height, shortSide int, portrait bool) (int, int) {
+	even := func(n int) int { return n / 2 * 2 }
+	if portrait {
+		return even(shortSide), even(height * shortSide / width)
+	}
+	return even(width * shortSide / height), even(shortSide)
+}
+
+func run(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", append([]string{"-v", "error"}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package transcode
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// SpriteImage and SpriteTrack are the names Sprites writes into outDir
+	SpriteImage = "sprite.jpg"
+	SpriteTrack = "sprite.vtt"
+
+	spriteTileWidth   = 160
+	spriteColumns     = 10
+	spriteMaxTiles    = 100
+	spriteMinInterval = 2 // seconds
+)
+
+// SpriteSheet describes the grid of frames in a sprite image
+type SpriteSheet struct {
+	Interval   float64 // seconds between frames
+	Count      int
+	Columns    int
+	TileWidth  int
+	TileHeight int
+}
+
+// NewSpriteSheet lays out a sheet for a video: a frame every few seconds,
+// spread further apart for long videos so the sheet stays a sane size
+func NewSpriteSheet(width, height int, duration float64) (SpriteSheet, error) {
+	if width <= 0 || height <= 0 {
+		return SpriteSheet{}, fmt.Errorf("invalid source size %dx%d", width, height)
+	}
+	if duration <= 0 {
+		return SpriteSheet{}, fmt.Errorf("invalid duration %v", duration)
+	}
+	interval := math.Max(spriteMinInterval, math.Ceil(duration/spriteMaxTiles))
+	count := max(int(math.Ceil(duration/interval)), 1)
+	return SpriteSheet{
+		Interval:   interval,
+		Count:      count,
+		Columns:    min(count, spriteColumns),
+		TileWidth:  spriteTileWidth,
+		TileHeight: max(spriteTileWidth*height/width/2*2, 2),
+	}, nil
+}
+
+func (s SpriteSheet) rows() int {
+	return (s.Count + s.Columns - 1) / s.Columns
+}
+
+// VTT returns a WebVTT track mapping each interval to its tile in the
+// image at imageURL, using media fragments like "sprite.jpg#xywh=0,0,160,90"
+func (s SpriteSheet) VTT(imageURL string, duration float64) []byte {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i := range s.Count {
+		start := float64(i) * s.Interval
+		end := math.Min(start+s.Interval, duration)
+		x := i % s.Columns * s.TileWidth
+		y := i / s.Columns * s.TileHeight
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTimestamp(start), vttTimestamp(end), imageURL, x, y, s.TileWidth, s.TileHeight)
+	}
+	return []byte(b.String())
+}
+
+// ResolveSpriteTrack returns a track written by Sprites with its relative
+// references to SpriteImage replaced by imageURL, for when the image can't
+// be fetched relative to where the track is served from
+func ResolveSpriteTrack(track []byte, imageURL string) []byte {
+	lines := strings.Split(string(track), "\n")
+	for i, line := range lines {
+		if fragment, ok := strings.CutPrefix(line, SpriteImage+"#"); ok {
+			lines[i] = imageURL + "#" + fragment
+		}
+	}
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// Sprites renders the sheet for input into outDir as SpriteImage, along
+// with SpriteTrack pointing at it by relative URL
+func Sprites(ctx context.Context, input, outDir string, width, height int, duration float64) error {
+	if !Available() {
+		return ErrNoFFmpeg
+	}
+	sheet, err := NewSpriteSheet(width, height, duration)
+	if err != nil {
+		return err
+	}
+
+	filter := fmt.Sprintf("fps=1/%g,scale=%d:%d,tile=%dx%d",
+		sheet.Interval, sheet.TileWidth, sheet.TileHeight, sheet.Columns, sheet.rows())
+	err = run(ctx,
+		"-y",
+		"-i", input,
+		"-vf", filter,
+		"-frames:v", "1",
+		"-q:v", "4",
+		filepath.Join(outDir, SpriteImage),
+	)
+	if err != nil {
+		return fmt.Errorf("couldn't render sprite sheet: %w", err)
+	}
+
+	return os.WriteFile(filepath.Join(outDir, SpriteTrack), sheet.VTT(SpriteImage, duration), 0644)
+}
+
+// vttTimestamp formats seconds as hh:mm:ss.ttt
+func vttTimestamp(seconds float64) string {
+	ms := int64(math.Round(seconds * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3_600_000, ms/60_000%60, ms/1000%60, ms%1000)
+}
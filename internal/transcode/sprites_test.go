@@ -0,0 +1,117 @@
+package transcode
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNewSpriteSheet(t *testing.T) {
+	tests := []struct {
+		width, height int
+		duration      float64
+		want          SpriteSheet
+	}{
+		// a frame every 2 seconds, the last interval partial
+		{1920, 1080, 9, SpriteSheet{Interval: 2, Count: 5, Columns: 5, TileWidth: 160, TileHeight: 90}},
+		{1280, 720, 25, SpriteSheet{Interval: 2, Count: 13, Columns: 10, TileWidth: 160, TileHeight: 90}},
+		{1280, 720, 0.5, SpriteSheet{Interval: 2, Count: 1, Columns: 1, TileWidth: 160, TileHeight: 90}},
+		// long videos are spread over at most 100 tiles
+		{1920, 1080, 1000, SpriteSheet{Interval: 10, Count: 100, Columns: 10, TileWidth: 160, TileHeight: 90}},
+		{1920, 1080, 1001, SpriteSheet{Interval: 11, Count: 91, Columns: 10, TileWidth: 160, TileHeight: 90}},
+		// tile heights are even, and at least 2
+		{1080, 1920, 10, SpriteSheet{Interval: 2, Count: 5, Columns: 5, TileWidth: 160, TileHeight: 284}},
+		{1000, 333, 10, SpriteSheet{Interval: 2, Count: 5, Columns: 5, TileWidth: 160, TileHeight: 52}},
+		{4000, 10, 10, SpriteSheet{Interval: 2, Count: 5, Columns: 5, TileWidth: 160, TileHeight: 2}},
+	}
+	for _, tt := range tests {
+		name := fmt.Sprintf("%dx%d %gs", tt.width, tt.height, tt.duration)
+		got, err := NewSpriteSheet(tt.width, tt.height, tt.duration)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", name, got, tt.want)
+		}
+	}
+
+	for _, bad := range [][3]float64{{0, 1080, 10}, {1920, -1, 10}, {1920, 1080, 0}} {
+		_, err := NewSpriteSheet(int(bad[0]), int(bad[1]), bad[2])
+		if err == nil {
+			t.Errorf("NewSpriteSheet(%v) succeeded", bad)
+		}
+	}
+}
+
+func TestSpriteSheetVTT(t *testing.T) {
+	sheet, err := NewSpriteSheet(1920, 1080, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "WEBVTT\n" +
+		"\n00:00:00.000 --> 00:00:02.000\nsprite.jpg#xywh=0,0,160,90\n" +
+		"\n00:00:02.000 --> 00:00:04.000\nsprite.jpg#xywh=160,0,160,90\n" +
+		"\n00:00:04.000 --> 00:00:05.000\nsprite.jpg#xywh=320,0,160,90\n"
+	if got := string(sheet.VTT(SpriteImage, 5)); got != want {
+		t.Fatalf("got %q\nwant %q", got, want)
+	}
+}
+
+func TestSpriteSheetVTTGrid(t *testing.T) {
+	// 13 tiles: a full row of 10 and 3 on the second
+	sheet, err := NewSpriteSheet(1280, 720, 25.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cues := strings.Split(strings.TrimPrefix(string(sheet.VTT(SpriteImage, 25.5)), "WEBVTT\n\n"), "\n\n")
+	if len(cues) != 13 || sheet.rows() != 2 {
+		t.Fatalf("%d cues in %d rows, want 13 in 2", len(cues), sheet.rows())
+	}
+	tests := []struct {
+		i    int
+		want string
+	}{
+		{0, "00:00:00.000 --> 00:00:02.000\nsprite.jpg#xywh=0,0,160,90"},
+		{9, "00:00:18.000 --> 00:00:20.000\nsprite.jpg#xywh=1440,0,160,90"},
+		{10, "00:00:20.000 --> 00:00:22.000\nsprite.jpg#xywh=0,90,160,90"},
+		{12, "00:00:24.000 --> 00:00:25.500\nsprite.jpg#xywh=320,90,160,90\n"},
+	}
+	for _, tt := range tests {
+		if cues[tt.i] != tt.want {
+			t.Errorf("cue %d = %q, want %q", tt.i, cues[tt.i], tt.want)
+		}
+	}
+}
+
+func TestVTTTimestamp(t *testing.T) {
+	tests := map[float64]string{
+		0:       "00:00:00.000",
+		1.5:     "00:00:01.500",
+		59.9996: "00:01:00.000",
+		3661.25: "01:01:01.250",
+	}
+	for seconds, want := range tests {
+		if got := vttTimestamp(seconds); got != want {
+			t.Errorf("vttTimestamp(%v) = %q, want %q", seconds, got, want)
+		}
+	}
+}
+
+func TestResolveSpriteTrack(t *testing.T) {
+	sheet, err := NewSpriteSheet(1920, 1080, 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageURL := "https://cdn.example.com/previews/abc/sprite.jpg?Expires=1&Signature=x~y"
+	got := string(ResolveSpriteTrack(sheet.VTT(SpriteImage, 25), imageURL))
+	if want := string(sheet.VTT(imageURL, 25)); got != want {
+		t.Fatalf("got %q\nwant %q", got, want)
+	}
+
+	// only cue payloads that start with the relative name are rewritten
+	track := "WEBVTT\n\nNOTE sprite.jpg#xywh=0,0,1,1\n\n00:00.000 --> 00:02.000\nother.jpg#xywh=0,0,1,1\n"
+	if got := string(ResolveSpriteTrack([]byte(track), imageURL)); got != track {
+		t.Fatalf("rewrote lines that aren't sprite references: %q", got)
+	}
+}
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/cloudfront"
 	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
-//	"github.com/google/uuid"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
 
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
 )
 
 type apiConfig struct {
@@ -18,9 +22,20 @@ type apiConfig struct {
 	platform         string
 	filepathRoot     string
 	assetsRoot       string
+	uploadsRoot      string
 	s3Bucket         string
 	s3Region         string
 	s3CfDistribution string
+	cfDomain         string
+	cfSigning        string
+	cfSigner         *cloudfront.Signer
+	cfSourceIP       string
+	cfCookieDomain   string
+	privateBucket    bool
+	presignTTL       time.Duration
+	jobs             *jobs.Queue
+	tusLocks         *uploadLocks
+	storage          storage.Storage
 	port             string
 }
 
@@ -37,10 +52,23 @@ func main() {
 		log.Fatal("DB_URL must be set")
 	}
 
+	// migrate runs before anything else, as connecting applies pending
+	// migrations
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err := runMigrate(pathToDB, os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	db, err := database.NewClient(pathToDB)
 	if err != nil {
 		log.Fatalf("Couldn't connect to database: %v", err)
 	}
+	if !db.FullTextSearch() {
+		log.Print("SQLite was built without FTS5, so search scans every video; build with -tags sqlite_fts5 for a full-text index")
+	}
 
 	jwtSecret := os.Getenv("JWT_SECRET")
 	if jwtSecret == "" {
@@ -62,21 +90,82 @@ func main() {
 		log.Fatal("ASSETS_ROOT environment variable is not set")
 	}
 
+	uploadsRoot := os.Getenv("UPLOADS_ROOT")
+	if uploadsRoot == "" {
+		uploadsRoot = "./uploads"
+	}
+
+	storageBackend := os.Getenv("STORAGE_BACKEND")
+	if storageBackend == "" {
+		storageBackend = "s3"
+	}
+
+	// the S3 settings are only needed when files go to S3
 	s3Bucket := os.Getenv("S3_BUCKET")
-	if s3Bucket == "" {
+	if s3Bucket == "" && storageBackend == "s3" {
 		log.Fatal("S3_BUCKET environment variable is not set")
 	}
 
 	s3Region := os.Getenv("S3_REGION")
-	if s3Region == "" {
+	if s3Region == "" && storageBackend == "s3" {
 		log.Fatal("S3_REGION environment variable is not set")
 	}
 
 	s3CfDistribution := os.Getenv("S3_CF_DISTRO")
-	if s3CfDistribution == "" {
+	if s3CfDistribution == "" && storageBackend == "s3" {
 		log.Fatal("S3_CF_DISTRO environment variable is not set")
 	}
 
+	store, err := newStorage(storageBackend, assetsRoot, s3Bucket, s3Region, os.Getenv("S3_ENDPOINT"))
+	if err != nil {
+		log.Fatalf("Couldn't set up storage: %v", err)
+	}
+
+	// in private mode only presigned URLs can reach the bucket
+	privateBucket := os.Getenv("S3_PRIVATE_BUCKET") == "true"
+	if _, ok := store.(storage.Presigner); privateBucket && !ok {
+		log.Fatal("S3_PRIVATE_BUCKET needs the s3 storage backend")
+	}
+
+	presignTTL := 15 * time.Minute
+	if ttl := os.Getenv("PRESIGN_URL_TTL"); ttl != "" {
+		presignTTL, err = time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatalf("PRESIGN_URL_TTL is not a valid duration: %v", err)
+		}
+	}
+
+	jobWorkers := 2
+	if workers := os.Getenv("JOB_WORKERS"); workers != "" {
+		jobWorkers, err = strconv.Atoi(workers)
+		if err != nil || jobWorkers < 1 {
+			log.Fatalf("JOB_WORKERS must be a positive number")
+		}
+	}
+
+	// with CF_ENABLED, files in S3 are served through the CloudFront
+	// distribution instead of straight from the bucket
+	cfDomain := ""
+	if os.Getenv("CF_ENABLED") == "true" {
+		if storageBackend != "s3" {
+			log.Fatal("CF_ENABLED needs the s3 storage backend")
+		}
+		cfDomain = s3CfDistribution
+	}
+
+	cfSigning := os.Getenv("CF_SIGNING")
+	cfSigner, err := loadCloudFrontSigner(cfSigning, os.Getenv("CF_KEY_PAIR_ID"), os.Getenv("CF_PRIVATE_KEY_FILE"))
+	if err != nil {
+		log.Fatalf("Couldn't set up CloudFront signing: %v", err)
+	}
+	if cfSigner != nil && cfDomain == "" {
+		log.Fatal("CF_SIGNING needs CF_ENABLED=true")
+	}
+	// unsigned CloudFront URLs would be open to anyone
+	if privateBucket && cfDomain != "" && cfSigner == nil {
+		log.Fatal("S3_PRIVATE_BUCKET with CF_ENABLED needs CF_SIGNING set to url or cookie")
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		log.Fatal("PORT environment variable is not set")
@@ -88,9 +177,20 @@ func main() {
 		platform:         platform,
 		filepathRoot:     filepathRoot,
 		assetsRoot:       assetsRoot,
+		uploadsRoot:      uploadsRoot,
 		s3Bucket:         s3Bucket,
 		s3Region:         s3Region,
 		s3CfDistribution: s3CfDistribution,
+		cfDomain:         cfDomain,
+		cfSigning:        cfSigning,
+		cfSigner:         cfSigner,
+		cfSourceIP:       os.Getenv("CF_SOURCE_IP"),
+		cfCookieDomain:   os.Getenv("CF_COOKIE_DOMAIN"),
+		privateBucket:    privateBucket,
+		presignTTL:       presignTTL,
+		jobs:             jobs.NewQueue(db, jobWorkers),
+		tusLocks:         newUploadLocks(),
+		storage:          store,
 		port:             port,
 	}
 
@@ -99,12 +199,36 @@ func main() {
 		log.Fatalf("Couldn't create assets directory: %v", err)
 	}
 
+	err = os.MkdirAll(uploadsRoot, 0755)
+	if err != nil {
+		log.Fatalf("Couldn't create uploads directory: %v", err)
+	}
+
+	if len(os.Args) > 1 {
+		err = cfg.runCommand(os.Args[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	cfg.jobs.Handle(jobTranscodeHLS, cfg.handleTranscodeJob)
+	cfg.jobs.OnDead(jobTranscodeHLS, cfg.handleTranscodeJobDead)
+	cfg.jobs.Handle(jobExtractFrames, cfg.handleExtractFramesJob)
+	cfg.jobs.Handle(jobGeneratePreview, cfg.handleGeneratePreviewJob)
+	cfg.jobs.Handle(jobDeleteObjects, cfg.handleDeleteObjectsJob)
+	cfg.jobs.Handle(jobIngestUpload, cfg.handleIngestUploadJob)
+	cfg.jobs.OnDead(jobIngestUpload, cfg.handleIngestUploadJobDead)
+	go cfg.jobs.Run(context.Background())
+
 	mux := http.NewServeMux()
 	appHandler := http.StripPrefix("/app", http.FileServer(http.Dir(filepathRoot)))
 	mux.Handle("/app/", appHandler)
 
-	assetsHandler := http.StripPrefix("/assets", http.FileServer(http.Dir(assetsRoot)))
-	mux.Handle("/assets/", cacheMiddleware(assetsHandler))
+	// thumbnails are public; every other stored file is served by a handler
+	// that checks access to its video
+	assetsHandler := http.StripPrefix("/assets", http.FileServer(fileOnlyFS{http.Dir(assetsRoot)}))
+	mux.Handle("/assets/thumbnails/", cacheMiddleware(assetsHandler))
 
 	mux.HandleFunc("POST /api/login", cfg.handlerLogin)
 	mux.HandleFunc("POST /api/refresh", cfg.handlerRefresh)
@@ -114,10 +238,24 @@ func main() {
 
 	mux.HandleFunc("POST /api/videos", cfg.handlerVideoMetaCreate)
 	mux.HandleFunc("POST /api/thumbnail_upload/{videoID}", cfg.handlerUploadThumbnail)
+	mux.HandleFunc("PUT /api/videos/{videoID}/thumbnail", cfg.handlerThumbnailCandidateSelect)
+	mux.HandleFunc("POST /api/videos/{videoID}/captions", cfg.handlerUploadCaptions)
 	mux.HandleFunc("POST /api/video_upload/{videoID}", cfg.handlerUploadVideo)
+	mux.HandleFunc("POST /api/videos/{videoID}/upload_url", cfg.handlerVideoUploadURL)
+	mux.HandleFunc("POST /api/videos/{videoID}/upload_complete", cfg.handlerVideoUploadComplete)
+	mux.HandleFunc("OPTIONS /api/tus/", cfg.handlerTusOptions)
+	mux.HandleFunc("POST /api/videos/{videoID}/tus", cfg.handlerTusCreate)
+	mux.HandleFunc("HEAD /api/tus/{uploadID}", cfg.handlerTusHead)
+	mux.HandleFunc("PATCH /api/tus/{uploadID}", cfg.handlerTusPatch)
+	mux.HandleFunc("DELETE /api/tus/{uploadID}", cfg.handlerTusDelete)
 	mux.HandleFunc("GET /api/videos", cfg.handlerVideosRetrieve)
+	mux.HandleFunc("GET /api/videos/search", cfg.handlerVideosSearch)
 	mux.HandleFunc("GET /api/videos/{videoID}", cfg.handlerVideoGet)
-	//mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
+	mux.HandleFunc("PATCH /api/videos/{videoID}", cfg.handlerVideoMetaUpdate)
+	mux.HandleFunc("GET /api/videos/{videoID}/stream", cfg.handlerVideoStream)
+	mux.HandleFunc("GET /api/videos/{videoID}/preview.vtt", cfg.handlerVideoPreviewTrack)
+	mux.HandleFunc("GET /api/videos/{videoID}/media/{token}/{key...}", cfg.handlerVideoMedia)
+	mux.HandleFunc("GET /api/thumbnails/{videoID}", cfg.handlerThumbnailGet)
 	mux.HandleFunc("DELETE /api/videos/{videoID}", cfg.handlerVideoMetaDelete)
 
 	mux.HandleFunc("POST /admin/reset", cfg.handlerReset)
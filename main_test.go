@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+	"github.com/google/uuid"
+)
+
+// newTestConfig returns a config with a fresh SQLite database and local
+// storage in a temp directory. Jobs are queued but never run.
+func newTestConfig(t *testing.T) *apiConfig {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := database.NewClient(filepath.Join(dir, "tubely.db"))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	assetsRoot := filepath.Join(dir, "assets")
+	return &apiConfig{
+		db:          db,
+		jwtSecret:   "secret",
+		platform:    "dev",
+		assetsRoot:  assetsRoot,
+		uploadsRoot: filepath.Join(dir, "uploads"),
+		presignTTL:  15 * time.Minute,
+		jobs:        jobs.NewQueue(db, 1),
+		tusLocks:    newUploadLocks(),
+		storage:     storage.NewLocal(assetsRoot, "/assets"),
+	}
+}
+
+// createTestUser adds a user and returns their ID and an access token
+func createTestUser(t *testing.T, cfg *apiConfig) (uuid.UUID, string) {
+	t.Helper()
+	user, err := cfg.db.CreateUser(database.CreateUserParams{
+		Email:    uuid.NewString() + "@example.com",
+		Password: "password",
+	})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	token, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user.ID, token
+}
+
+// createTestVideo adds a video owned by userID
+func createTestVideo(t *testing.T, cfg *apiConfig, userID uuid.UUID) database.Video {
+	t.Helper()
+	video, err := cfg.db.CreateVideo(database.CreateVideoParams{
+		Title:       "Boots",
+		Description: "A bear",
+		UserID:      userID,
+	})
+	if err != nil {
+		t.Fatalf("CreateVideo: %v", err)
+	}
+	return video
+}
+
+// serve runs r through handler as the user with token, if any, and returns
+// the response
+func serve(t *testing.T, handler http.HandlerFunc, pattern string, r *http.Request, token string) *http.Response {
+	t.Helper()
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(pattern, handler)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	return w.Result()
+}
+
+// decodeBody decodes a JSON response into v
+func decodeBody(t *testing.T, res *http.Response, v any) {
+	t.Helper()
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/imaging"
+)
+
+// migrateThumbnails moves thumbnails that were saved as base64 data URLs in
+// the videos table into storage and points the rows at the stored files
+func (cfg *apiConfig) migrateThumbnails(ctx context.Context) error {
+	videos, err := cfg.db.GetVideosWithDataThumbnails()
+	if err != nil {
+		return fmt.Errorf("couldn't list videos: %w", err)
+	}
+
+	migrated := 0
+	for _, video := range videos {
+		image, _, err := decodeDataURL(*video.ThumbnailURL)
+		if err != nil {
+			log.Printf("skipping video %s: %v", video.ID, err)
+			continue
+		}
+		keys, err := cfg.saveThumbnail(ctx, &video, image)
+		if errors.Is(err, imaging.ErrInvalidImage) {
+			log.Printf("skipping video %s: %v", video.ID, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("couldn't store thumbnail for video %s: %w", video.ID, err)
+		}
+		released, err := cfg.db.SetVideoThumbnail(video.ID, *video.ThumbnailURL, video.Thumbnails, video.CustomThumbnail, keys)
+		if err != nil {
+			return fmt.Errorf("couldn't update video %s: %w", video.ID, err)
+		}
+		cfg.deleteBlobs(released)
+		migrated++
+	}
+
+	log.Printf("migrated %d of %d thumbnails", migrated, len(videos))
+	return nil
+}
+
+// decodeDataURL parses a "data:<media type>;base64,<data>" URL
+func decodeDataURL(dataURL string) ([]byte, string, error) {
+	rest, ok := strings.CutPrefix(dataURL, "data:")
+	if !ok {
+		return nil, "", errors.New("not a data URL")
+	}
+	meta, encoded, ok := strings.Cut(rest, ",")
+	if !ok {
+		return nil, "", errors.New("malformed data URL")
+	}
+	mediaType, ok := strings.CutSuffix(meta, ";base64")
+	if !ok {
+		return nil, "", errors.New("data URL is not base64 encoded")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, mediaType, nil
+}
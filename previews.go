@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/google/uuid"
+)
+
+// jobGeneratePreview renders the seek bar preview sprites for a video
+const jobGeneratePreview = "generate_preview"
+
+// previewPrefix is where the seek bar previews of source are stored
+func previewPrefix(videoID uuid.UUID, source string) string {
+	return "previews/" + videoID.String() + "/" + sourceDir(source) + "/"
+}
+
+// enqueuePreview schedules seek bar previews of the video file at source
+// when ffmpeg is available
+func (cfg *apiConfig) enqueuePreview(videoID uuid.UUID, source string) {
+	if !transcode.Available() {
+		return
+	}
+	err := cfg.jobs.Enqueue(jobGeneratePreview, videoJob{VideoID: videoID, Source: source})
+	if err != nil {
+		log.Printf("couldn't queue preview for video %s: %v", videoID, err)
+	}
+}
+
+func (cfg *apiConfig) handleGeneratePreviewJob(ctx context.Context, payload json.RawMessage) error {
+	var job videoJob
+	err := json.Unmarshal(payload, &job)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	err = cfg.generatePreview(ctx, job)
+	if errors.Is(err, transcode.ErrNoFFmpeg) {
+		return jobs.Permanent(err)
+	}
+	return err
+}
+
+// generatePreview stores a sprite sheet and its WebVTT track for the job's
+// video file under previewPrefix and records the track on the video if the
+// file is still its current one. The track refers to the sheet by a
+// relative URL so it resolves wherever the track is served from.
+func (cfg *apiConfig) generatePreview(ctx context.Context, job videoJob) error {
+	video, err := cfg.db.GetVideo(job.VideoID)
+	if err != nil {
+		return err
+	}
+	// deleted or replaced while waiting in the queue
+	source, ok := job.source(video)
+	if !ok {
+		return nil
+	}
+
+	input, info, cleanup, err := cfg.downloadVideoFile(ctx, video)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	outDir, err := os.MkdirTemp("", "tubely-preview-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(outDir)
+
+	err = transcode.Sprites(ctx, input, outDir, info.Width, info.Height, info.Duration)
+	if err != nil {
+		return err
+	}
+
+	prefix := previewPrefix(video.ID, source)
+	err = cfg.uploadDir(ctx, outDir, prefix)
+	if err != nil {
+		return err
+	}
+
+	trackURL := cfg.objectURL(prefix + transcode.SpriteTrack)
+	_, err = cfg.db.SetVideoSourcePreviewVTTURL(video.ID, source, trackURL)
+	return err
+}
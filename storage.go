@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// newStorage builds the blob storage picked by STORAGE_BACKEND. An empty
+// s3Endpoint talks to AWS, anything else (e.g. a MinIO container) is used
+// with path-style addressing.
+func newStorage(backend, assetsRoot, s3Bucket, s3Region, s3Endpoint string) (storage.Storage, error) {
+	switch backend {
+	case "local":
+		return storage.NewLocal(assetsRoot, "/assets"), nil
+	case "s3":
+		awsCfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(s3Region))
+		if err != nil {
+			return nil, fmt.Errorf("couldn't load AWS config: %w", err)
+		}
+		if s3Endpoint == "" {
+			client := s3.NewFromConfig(awsCfg)
+			baseURL := fmt.Sprintf("https://%s.s3.%s.amazonaws.com", s3Bucket, s3Region)
+			return storage.NewS3(client, s3Bucket, baseURL), nil
+		}
+		client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+			o.BaseEndpoint = aws.String(s3Endpoint)
+			o.UsePathStyle = true
+		})
+		return storage.NewS3(client, s3Bucket, s3Endpoint+"/"+s3Bucket), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+// objectURL is what gets stored in the database for the object at key. In
+// private mode that is a "bucket,key" pair that is swapped for a presigned
+// URL on every read, otherwise it's the object's permanent URL.
+func (cfg *apiConfig) objectURL(key string) string {
+	if cfg.privateBucket {
+		return cfg.s3Bucket + "," + key
+	}
+	return cfg.storage.URL(key)
+}
+
+// storageKey turns a URL produced by cfg.objectURL back into its key
+func (cfg *apiConfig) storageKey(url string) (string, bool) {
+	if cfg.s3Bucket != "" {
+		if key, ok := strings.CutPrefix(url, cfg.s3Bucket+","); ok && key != "" {
+			return key, true
+		}
+	}
+	if cfg.cfDomain != "" {
+		if key, ok := strings.CutPrefix(url, "https://"+cfg.cfDomain+"/"); ok && key != "" {
+			return key, true
+		}
+	}
+	key, ok := strings.CutPrefix(url, cfg.storage.URL(""))
+	if !ok || key == "" {
+		return "", false
+	}
+	return key, true
+}
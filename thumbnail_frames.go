@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/imaging"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/google/uuid"
+)
+
+// jobExtractFrames takes thumbnail candidates from a video's frames
+const jobExtractFrames = "extract_frames"
+
+// enqueueFrameExtraction schedules thumbnail candidates from the video file
+// at source when ffmpeg is available
+func (cfg *apiConfig) enqueueFrameExtraction(videoID uuid.UUID, source string) {
+	if !transcode.Available() {
+		return
+	}
+	err := cfg.jobs.Enqueue(jobExtractFrames, videoJob{VideoID: videoID, Source: source})
+	if err != nil {
+		log.Printf("couldn't queue frame extraction for video %s: %v", videoID, err)
+	}
+}
+
+func (cfg *apiConfig) handleExtractFramesJob(ctx context.Context, payload json.RawMessage) error {
+	var job videoJob
+	err := json.Unmarshal(payload, &job)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	err = cfg.extractFrames(ctx, job)
+	if errors.Is(err, transcode.ErrNoFFmpeg) || errors.Is(err, imaging.ErrInvalidImage) {
+		return jobs.Permanent(err)
+	}
+	return err
+}
+
+// extractFrames stores frames from the video as thumbnail candidates and
+// makes the first one the thumbnail if the user hasn't uploaded their own
+func (cfg *apiConfig) extractFrames(ctx context.Context, job videoJob) error {
+	videoID := job.VideoID
+	video, err := cfg.db.GetVideo(videoID)
+	if err != nil {
+		return err
+	}
+	// deleted or replaced while waiting in the queue
+	source, ok := job.source(video)
+	if !ok {
+		return nil
+	}
+	input, info, cleanup, err := cfg.downloadVideoFile(ctx, video)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	outDir, err := os.MkdirTemp("", "tubely-frames-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(outDir)
+
+	paths, err := transcode.Frames(ctx, input, outDir, info.Duration)
+	if err != nil {
+		return err
+	}
+
+	candidates := database.ThumbnailCandidates{}
+	keys := []string{}
+	var first []byte
+	for _, path := range paths {
+		frame, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		frameSum := sha256.Sum256(frame)
+		sum := hex.EncodeToString(frameSum[:])
+		frameKey, err := cfg.storeBlob(ctx, "thumbnails/"+sum+"/frame.jpg", sum,
+			bytes.NewReader(frame), int64(len(frame)), "image/jpeg")
+		if err != nil {
+			return err
+		}
+		keys = append(keys, frameKey)
+		candidates = append(candidates, cfg.objectURL(frameKey))
+		if first == nil {
+			first = frame
+		}
+	}
+
+	// the file may have been replaced while ffmpeg ran, so every write is
+	// made only if it's still the one the frames came from
+	released, ok, err := cfg.db.SetVideoThumbnailCandidates(videoID, source, candidates, keys)
+	if err != nil {
+		return err
+	}
+	cfg.deleteBlobs(released)
+	if !ok {
+		cfg.discardBlobs(keys)
+		return nil
+	}
+	if video.CustomThumbnail || first == nil {
+		return nil
+	}
+	thumbnailKeys, err := cfg.saveThumbnail(ctx, &video, first)
+	if err != nil {
+		return err
+	}
+	released, ok, err = cfg.db.SetVideoExtractedThumbnail(videoID, source, *video.ThumbnailURL, video.Thumbnails, thumbnailKeys)
+	if err != nil {
+		return err
+	}
+	cfg.deleteBlobs(released)
+	if !ok {
+		cfg.discardBlobs(thumbnailKeys)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/jobs"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/transcode"
+	"github.com/google/uuid"
+)
+
+// jobTranscodeHLS builds the HLS ladder for a video
+const jobTranscodeHLS = "transcode_hls"
+
+// videoJob is the payload of jobs about a single video
+type videoJob struct {
+	VideoID uuid.UUID `json:"video_id"`
+	// Source is the video_url the job was queued for. Jobs queued before it
+	// was recorded work from whatever file the video has.
+	Source string `json:"source,omitempty"`
+}
+
+// source returns the file the job works from, or false if the video is gone
+// or its file was replaced after the job was queued. A newer job handles
+// the new file.
+func (job videoJob) source(video database.Video) (string, bool) {
+	if video.ID == uuid.Nil || video.VideoURL == nil {
+		return "", false
+	}
+	if job.Source != "" && job.Source != *video.VideoURL {
+		return "", false
+	}
+	return *video.VideoURL, true
+}
+
+// sourceDir names the directory for files generated from the video file at
+// source. Every upload gets its own, so a job still working on an older file
+// can't overwrite the output for the current one.
+func sourceDir(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:8])
+}
+
+// hlsPrefix is where the HLS ladder built from source is stored
+func hlsPrefix(videoID uuid.UUID, source string) string {
+	return "hls/" + videoID.String() + "/" + sourceDir(source) + "/"
+}
+
+// enqueueTranscode schedules HLS transcoding of the video file at source
+// and reports whether it did. Without ffmpeg the video is only ever served
+// as the uploaded MP4.
+func (cfg *apiConfig) enqueueTranscode(videoID uuid.UUID, source string) bool {
+	if !transcode.Available() {
+		return false
+	}
+	err := cfg.jobs.Enqueue(jobTranscodeHLS, videoJob{VideoID: videoID, Source: source})
+	if err != nil {
+		log.Printf("couldn't queue transcoding of video %s: %v", videoID, err)
+		return false
+	}
+	return true
+}
+
+func (cfg *apiConfig) handleTranscodeJob(ctx context.Context, payload json.RawMessage) error {
+	var job videoJob
+	err := json.Unmarshal(payload, &job)
+	if err != nil {
+		return jobs.Permanent(err)
+	}
+	err = cfg.transcodeVideo(ctx, job)
+	if errors.Is(err, transcode.ErrNoFFmpeg) {
+		return jobs.Permanent(err)
+	}
+	return err
+}
+
+// handleTranscodeJobDead fails a video whose transcoding ran out of retries
+func (cfg *apiConfig) handleTranscodeJobDead(payload json.RawMessage, jobErr error) {
+	var job videoJob
+	if json.Unmarshal(payload, &job) != nil {
+		return
+	}
+	video, err := cfg.db.GetVideo(job.VideoID)
+	if err != nil {
+		log.Printf("couldn't get video %s: %v", job.VideoID, err)
+		return
+	}
+	if _, ok := job.source(video); !ok {
+		return
+	}
+	err = cfg.db.SetVideoStatus(job.VideoID, database.VideoFailed, "Couldn't transcode video")
+	if err != nil && !errors.Is(err, database.ErrInvalidTransition) {
+		log.Printf("couldn't mark video %s failed: %v", job.VideoID, err)
+	}
+}
+
+// transcodeVideo builds the HLS ladder for the job's video file, stores it
+// under hlsPrefix, and records the master playlist and makes the video ready
+// if the file is still the video's current one
+func (cfg *apiConfig) transcodeVideo(ctx context.Context, job videoJob) error {
+	video, err := cfg.db.GetVideo(job.VideoID)
+	if err != nil {
+		return err
+	}
+	// deleted or replaced while waiting in the queue
+	source, ok := job.source(video)
+	if !ok {
+		return nil
+	}
+	input, info, cleanup, err := cfg.downloadVideoFile(ctx, video)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	outDir, err := os.MkdirTemp("", "tubely-hls-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(outDir)
+
+	err = transcode.HLS(ctx, input, outDir, info.Width, info.Height)
+	if err != nil {
+		return err
+	}
+
+	prefix := hlsPrefix(video.ID, source)
+	err = cfg.uploadDir(ctx, outDir, prefix)
+	if err != nil {
+		return err
+	}
+
+	// a new upload may have replaced the file meanwhile, its own job
+	// finishes it and gc collects what this one stored
+	manifestURL := cfg.objectURL(prefix + transcode.MasterPlaylist)
+	_, err = cfg.db.FinishVideoTranscode(video.ID, source, manifestURL)
+	return err
+}
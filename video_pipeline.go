@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/media"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/mp4"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// finalizeVideoUpload takes a fully received video file and its SHA-256,
+// probes it, moves it into storage under its orientation and records the
+// result on video. The video goes from uploading to processing, and to
+// failed if the file can't be used.
+func (cfg *apiConfig) finalizeVideoUpload(ctx context.Context, video *database.Video, path, hash string) error {
+	err := cfg.setVideoStatus(video, database.VideoProcessing, "")
+	if err != nil {
+		return err
+	}
+	err = cfg.storeVideoFile(ctx, video, path, hash)
+	if err != nil {
+		cfg.failVideo(video, err)
+		return err
+	}
+	return cfg.processVideo(video)
+}
+
+func (cfg *apiConfig) storeVideoFile(ctx context.Context, video *database.Video, path, hash string) error {
+	info, err := media.Probe(ctx, path)
+	if err != nil {
+		return err
+	}
+	orientation := info.Orientation()
+
+	key, err := cfg.storeVideoBlob(ctx, path, hash, orientation)
+	if err != nil {
+		return err
+	}
+
+	file := database.VideoFile{
+		URL:         cfg.objectURL(key),
+		Width:       info.Width,
+		Height:      info.Height,
+		Duration:    info.Duration,
+		Codec:       info.Codec,
+		Orientation: orientation,
+	}
+	released, err := cfg.db.SetVideoFile(video.ID, file, key)
+	if err != nil {
+		return err
+	}
+	cfg.deleteBlobs(released)
+
+	video.VideoURL = &file.URL
+	video.Width = &file.Width
+	video.Height = &file.Height
+	video.Duration = &file.Duration
+	video.Codec = &file.Codec
+	video.Orientation = &file.Orientation
+	return nil
+}
+
+// storeVideoBlob stores the video file at path, whose SHA-256 is hash, as
+// a fast start MP4 and returns its key. The blob's hash is of the stored
+// file, so a file that was uploaded before is not stored again whether or
+// not it had to be rewritten.
+func (cfg *apiConfig) storeVideoBlob(ctx context.Context, path, hash, orientation string) (string, error) {
+	existing, err := cfg.db.GetBlobByHash(hash)
+	if err != nil {
+		return "", err
+	}
+	if existing != nil {
+		return existing.Key, nil
+	}
+
+	fastPath, cleanup, err := fastStartFile(path)
+	if err != nil {
+		return "", err
+	}
+	defer cleanup()
+	if fastPath != path {
+		path = fastPath
+		hash, err = fileSHA256(path)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	name, err := randomFileKey(".mp4")
+	if err != nil {
+		return "", err
+	}
+	key := orientation + "/" + name
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	return cfg.storeBlob(ctx, key, hash, f, stat.Size(), "video/mp4")
+}
+
+// processVideo starts the background work for a video whose new file is
+// in place. It stays processing until transcoding is done, or becomes
+// ready right away when there is nothing to do.
+func (cfg *apiConfig) processVideo(video *database.Video) error {
+	// the old ladder and previews no longer match the file
+	video.HLSManifestURL = nil
+	err := cfg.db.SetVideoHLSManifestURL(video.ID, nil)
+	if err != nil {
+		return err
+	}
+	video.PreviewVTTURL = nil
+	err = cfg.db.SetVideoPreviewVTTURL(video.ID, nil)
+	if err != nil {
+		return err
+	}
+	source := *video.VideoURL
+	cfg.enqueueFrameExtraction(video.ID, source)
+	cfg.enqueuePreview(video.ID, source)
+	if cfg.enqueueTranscode(video.ID, source) {
+		return nil
+	}
+	return cfg.setVideoStatus(video, database.VideoReady, "")
+}
+
+// setVideoStatus moves the video to status in the database and mirrors the
+// change on video
+func (cfg *apiConfig) setVideoStatus(video *database.Video, status database.VideoStatus, failureReason string) error {
+	err := cfg.db.SetVideoStatus(video.ID, status, failureReason)
+	if err != nil {
+		return err
+	}
+	video.Status = status
+	video.FailureReason = nil
+	if status == database.VideoFailed {
+		video.FailureReason = &failureReason
+	}
+	return nil
+}
+
+// failVideo marks the video failed with a reason a user can act on. The
+// details of internal errors only go to the log.
+func (cfg *apiConfig) failVideo(video *database.Video, cause error) {
+	reason := "Couldn't process video"
+	if errors.Is(cause, media.ErrInvalidVideo) {
+		reason = "Not a valid MP4 video"
+	}
+	err := cfg.setVideoStatus(video, database.VideoFailed, reason)
+	if err != nil {
+		log.Printf("couldn't mark video %s failed: %v", video.ID, err)
+	}
+}
+
+// downloadVideoFile copies the video's current file into a temp file for
+// the background jobs, along with its metadata, probing the file for any
+// the row doesn't have. cleanup removes the file.
+func (cfg *apiConfig) downloadVideoFile(ctx context.Context, video database.Video) (string, media.Info, func(), error) {
+	noop := func() {}
+
+	key, ok := cfg.storageKey(*video.VideoURL)
+	if !ok {
+		return "", media.Info{}, noop, fmt.Errorf("video_url %q isn't in storage", *video.VideoURL)
+	}
+	input, cleanup, err := cfg.downloadToTemp(ctx, key)
+	if err != nil {
+		return "", media.Info{}, noop, err
+	}
+
+	if video.Width != nil && video.Height != nil && video.Duration != nil {
+		info := media.Info{Width: *video.Width, Height: *video.Height, Duration: *video.Duration}
+		return input, info, cleanup, nil
+	}
+	info, err := media.Probe(ctx, input)
+	if err != nil {
+		cleanup()
+		return "", media.Info{}, noop, err
+	}
+	return input, info, cleanup, nil
+}
+
+// uploadDir stores every file under dir at prefix plus its relative path
+func (cfg *apiConfig) uploadDir(ctx context.Context, dir, prefix string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		key := prefix + filepath.ToSlash(rel)
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return cfg.storage.Put(ctx, key, f, storage.ContentType(key))
+	})
+}
+
+// downloadToTemp copies the object at key into a temp file for tools that
+// need a path, like ffmpeg. cleanup removes the file.
+func (cfg *apiConfig) downloadToTemp(ctx context.Context, key string) (string, func(), error) {
+	noop := func() {}
+
+	body, _, err := cfg.storage.Get(ctx, key)
+	if err != nil {
+		return "", noop, err
+	}
+	defer body.Close()
+
+	f, err := os.CreateTemp("", "tubely-download-*"+path.Ext(key))
+	if err != nil {
+		return "", noop, err
+	}
+	cleanup := func() { os.Remove(f.Name()) }
+
+	_, err = io.Copy(f, body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", noop, err
+	}
+	return f.Name(), cleanup, nil
+}
+
+// fastStartFile returns the path of a copy of the MP4 at path with moov
+// moved to the front. The original path is returned unchanged when the file
+// already is fast start or can't be rewritten. cleanup removes the copy.
+func fastStartFile(path string) (string, func(), error) {
+	noop := func() {}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return "", noop, err
+	}
+	defer src.Close()
+
+	stat, err := src.Stat()
+	if err != nil {
+		return "", noop, err
+	}
+	ok, err := mp4.IsFastStart(src, stat.Size())
+	if err != nil {
+		return "", noop, fmt.Errorf("%w: %v", media.ErrInvalidVideo, err)
+	}
+	if ok {
+		return path, noop, nil
+	}
+
+	dst, err := os.CreateTemp("", "tubely-faststart-*.mp4")
+	if err != nil {
+		return "", noop, err
+	}
+	cleanup := func() { os.Remove(dst.Name()) }
+
+	err = mp4.FastStart(dst, src, stat.Size())
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if errors.Is(err, mp4.ErrOffsetOverflow) {
+		// still playable, just not progressively
+		cleanup()
+		log.Printf("couldn't make %s fast start: %v", path, err)
+		return path, noop, nil
+	}
+	if err != nil {
+		cleanup()
+		return "", noop, err
+	}
+	return dst.Name(), cleanup, nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/auth"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/database"
+	"github.com/bootdotdev/learn-file-storage-s3-golang-starter/internal/storage"
+)
+
+// signVideo swaps the stored object references on video for URLs the client
+// can use right now: a tokenized stream URL for local files, CloudFront URLs
+// (signed if CF_SIGNING is set) when the distribution is enabled, presigned
+// S3 URLs in private mode. Anything else is returned unchanged.
+func (cfg *apiConfig) signVideo(ctx context.Context, video database.Video) (database.Video, error) {
+	// locally stored media goes through handlers that check a media token
+	if _, ok := cfg.storage.(*storage.Local); ok {
+		return cfg.tokenizeLocalMedia(video)
+	}
+	if !cfg.privateBucket && cfg.cfDomain == "" {
+		return video, nil
+	}
+
+	var err error
+	video.VideoURL, err = cfg.signURL(ctx, video.VideoURL)
+	if err != nil {
+		return database.Video{}, err
+	}
+	video.ThumbnailURL, err = cfg.signURL(ctx, video.ThumbnailURL)
+	if err != nil {
+		return database.Video{}, err
+	}
+	// segments are fetched relative to the playlist, so a private bucket
+	// without CloudFront cookies can't serve HLS; the MP4 still works.
+	// handlerVideoGet sets cookies for the playlist's directory.
+	video.HLSManifestURL, err = cfg.signURL(ctx, video.HLSManifestURL)
+	if err != nil {
+		return database.Video{}, err
+	}
+	// the preview track points at its sprite sheet by a relative URL too,
+	// so when URLs are signed the API serves it with a signed one
+	if video.PreviewVTTURL != nil && (cfg.privateBucket || cfg.cfSigner != nil) {
+		trackURL, err := cfg.previewTrackURL(video.ID)
+		if err != nil {
+			return database.Video{}, err
+		}
+		video.PreviewVTTURL = &trackURL
+	} else {
+		video.PreviewVTTURL, err = cfg.signURL(ctx, video.PreviewVTTURL)
+		if err != nil {
+			return database.Video{}, err
+		}
+	}
+
+	// copy so the caller's map isn't modified
+	thumbnails := make(database.Thumbnails, len(video.Thumbnails))
+	for name, url := range video.Thumbnails {
+		signed, err := cfg.signURL(ctx, &url)
+		if err != nil {
+			return database.Video{}, err
+		}
+		thumbnails[name] = *signed
+	}
+	video.Thumbnails = thumbnails
+
+	candidates := make(database.ThumbnailCandidates, 0, len(video.ThumbnailCandidates))
+	for _, url := range video.ThumbnailCandidates {
+		signed, err := cfg.signURL(ctx, &url)
+		if err != nil {
+			return database.Video{}, err
+		}
+		candidates = append(candidates, *signed)
+	}
+	video.ThumbnailCandidates = candidates
+
+	tracks := make(database.Captions, 0, len(video.Captions))
+	for _, track := range video.Captions {
+		signed, err := cfg.signURL(ctx, &track.URL)
+		if err != nil {
+			return database.Video{}, err
+		}
+		track.URL = *signed
+		tracks = append(tracks, track)
+	}
+	video.Captions = tracks
+
+	return video, nil
+}
+
+// tokenizeLocalMedia points a locally stored video's file at the stream
+// handler and its other media at handlerVideoMedia, both with a media token.
+// /assets/ only serves thumbnails.
+func (cfg *apiConfig) tokenizeLocalMedia(video database.Video) (database.Video, error) {
+	if video.VideoURL != nil {
+		streamURL, err := cfg.streamURL(video.ID)
+		if err != nil {
+			return database.Video{}, err
+		}
+		video.VideoURL = &streamURL
+	}
+
+	token, err := auth.MakeMediaToken(video.ID, cfg.jwtSecret, cfg.presignTTL)
+	if err != nil {
+		return database.Video{}, err
+	}
+	tokenize := func(url *string) *string {
+		if url == nil {
+			return nil
+		}
+		key, ok := cfg.storageKey(*url)
+		if !ok {
+			return url
+		}
+		tokenized := mediaURL(video.ID, token, key)
+		return &tokenized
+	}
+	video.HLSManifestURL = tokenize(video.HLSManifestURL)
+	video.PreviewVTTURL = tokenize(video.PreviewVTTURL)
+	tracks := make(database.Captions, 0, len(video.Captions))
+	for _, track := range video.Captions {
+		track.URL = *tokenize(&track.URL)
+		tracks = append(tracks, track)
+	}
+	video.Captions = tracks
+	return video, nil
+}
+
+// publicVideo is video as shown to someone other than its owner. Locally
+// stored media is only served with a media token, which only the owner
+// gets, so the paths to it are left out.
+func (cfg *apiConfig) publicVideo(video database.Video) database.Video {
+	if _, ok := cfg.storage.(*storage.Local); !ok {
+		return video
+	}
+	video.VideoURL = nil
+	video.HLSManifestURL = nil
+	video.PreviewVTTURL = nil
+	video.Captions = database.Captions{}
+	return video
+}
+
+func (cfg *apiConfig) signVideos(ctx context.Context, videos []database.Video) ([]database.Video, error) {
+	signed := make([]database.Video, 0, len(videos))
+	for _, video := range videos {
+		video, err := cfg.signVideo(ctx, video)
+		if err != nil {
+			return nil, err
+		}
+		signed = append(signed, video)
+	}
+	return signed, nil
+}
+
+// signURL resolves a single stored reference. Anything that isn't one of
+// ours, like a URL from before a config change, is passed through.
+func (cfg *apiConfig) signURL(ctx context.Context, url *string) (*string, error) {
+	if url == nil {
+		return nil, nil
+	}
+	key, ok := cfg.storageKey(*url)
+	if !ok {
+		return url, nil
+	}
+
+	if cfg.cfDomain != "" {
+		cfURL := "https://" + cfg.cfDomain + "/" + key
+		if cfg.cfSigner == nil {
+			return &cfURL, nil
+		}
+		signed, err := cfg.cfSigner.SignURL(cfURL, cfg.cloudFrontPolicy(cfURL))
+		if err != nil {
+			return nil, err
+		}
+		return &signed, nil
+	}
+
+	presigner, ok := cfg.storage.(storage.Presigner)
+	if !ok {
+		return url, nil
+	}
+	signed, err := presigner.PresignGet(ctx, key, cfg.presignTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &signed, nil
+}